@@ -0,0 +1,184 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/language"
+)
+
+// Mode selects the conversion applied by Transform.
+type Mode string
+
+const (
+	ModeUpper    Mode = "upper"    // KEN JEONG
+	ModeLower    Mode = "lower"    // ken jeong
+	ModeTitle    Mode = "title"    // Ken Jeong
+	ModeSentence Mode = "sentence" // Ken jeong. Mr chow!
+	ModeSnake    Mode = "snake"    // ken_jeong
+	ModeKebab    Mode = "kebab"    // ken-jeong
+	ModeCamel    Mode = "camel"    // kenJeong
+	ModePascal   Mode = "pascal"   // KenJeong
+)
+
+var (
+	ErrUnknownMode   = errors.New("unknown transform mode")
+	ErrInvalidLocale = errors.New("invalid locale")
+)
+
+func (m Mode) valid() bool {
+	switch m {
+	case ModeUpper, ModeLower, ModeTitle, ModeSentence, ModeSnake, ModeKebab, ModeCamel, ModePascal:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseMode returns the Mode named s. The empty string means ModeUpper.
+func ParseMode(s string) (Mode, error) {
+	mode := Mode(strings.ToLower(s))
+
+	if mode == "" {
+		return ModeUpper, nil
+	}
+
+	if !mode.valid() {
+		return "", fmt.Errorf("%w: %q", ErrUnknownMode, s)
+	}
+
+	return mode, nil
+}
+
+// ParseLocale parses a BCP 47 language tag such as "tr" or "de-CH". The empty
+// string means no particular language.
+func ParseLocale(locale string) (language.Tag, error) {
+	if locale == "" {
+		return language.Und, nil
+	}
+
+	tag, err := language.Parse(locale)
+
+	if err != nil {
+		return language.Und, fmt.Errorf("%w: %q", ErrInvalidLocale, locale)
+	}
+
+	return tag, nil
+}
+
+// Transform converts text according to mode, using the casing rules of
+// locale, e.g. "tr" maps i to İ in upper case and "de" maps ß to SS. The text
+// is normalized to NFC first, so a letter followed by combining marks is
+// treated as one character. Use a Writer for text that does not fit in
+// memory.
+func Transform(text string, mode Mode, locale string) (string, error) {
+	var b strings.Builder
+	b.Grow(len(text))
+
+	tw, err := NewWriter(&b, mode, locale)
+
+	if err != nil {
+		return "", err
+	}
+
+	if err := tw.writeSegment([]byte(text)); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// isWordRune reports whether r belongs to a word. Combining marks belong to
+// the letter before them.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// splitWords splits text at everything that is not a letter, digit or mark,
+// and inside words at case changes: "helloWorld" is "hello" and "World", and
+// "HTTPServer" is "HTTP" and "Server".
+func splitWords(text string) []string {
+	words, _ := splitWordsAfter(text, nil)
+
+	return words
+}
+
+// wordTail is where splitWords stands at the end of a piece of text, so that
+// the next piece can carry on with the word it ended in.
+type wordTail struct {
+	inWord         bool
+	prev, prevPrev rune // the last two letters of the word, skipping marks
+}
+
+// splitWordsAfter is splitWords for text that follows a piece which ended as
+// tail describes. It updates tail for the next piece, and reports whether the
+// first word carries on the word tail ended in. A nil tail means text stands
+// alone.
+func splitWordsAfter(text string, tail *wordTail) (words []string, continued bool) {
+	var word []rune
+	var t wordTail
+
+	if tail != nil {
+		t = *tail
+	}
+
+	continued = t.inWord
+
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		} else if len(words) == 0 {
+			// the word of tail ended right where text starts
+			continued = false
+		}
+
+		t = wordTail{}
+	}
+
+	for _, r := range text {
+		if !isWordRune(r) {
+			flush()
+			continue
+		}
+
+		if unicode.IsMark(r) {
+			word = append(word, r)
+			t.inWord = true
+			continue
+		}
+
+		switch {
+		case unicode.IsUpper(r) && unicode.IsLower(t.prev):
+			// helloWorld
+			flush()
+		case unicode.IsLower(r) && unicode.IsUpper(t.prev) && unicode.IsUpper(t.prevPrev) && len(word) > 0:
+			// HTTPServer: the last upper case letter starts the new word
+			last := len(word) - 1
+
+			for last > 0 && unicode.IsMark(word[last]) {
+				last--
+			}
+
+			head := append([]rune{}, word[last:]...)
+			word = word[:last]
+			flush()
+			word = append(word, head...)
+			t.prevPrev, t.prev = 0, head[0]
+		}
+
+		word = append(word, r)
+		t.prevPrev, t.prev = t.prev, r
+		t.inWord = true
+	}
+
+	if tail != nil {
+		*tail = t
+	}
+
+	flush()
+
+	return words, continued
+}
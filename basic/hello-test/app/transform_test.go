@@ -0,0 +1,120 @@
+package app
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// transformCases are shared by the Transform and Writer tests.
+var transformCases = []struct {
+	name   string
+	input  string
+	mode   Mode
+	locale string
+	want   string
+}{
+	{name: "upper", input: "Ken Jeong!", mode: ModeUpper, want: "KEN JEONG!"},
+	{name: "lower", input: "Ken Jeong!", mode: ModeLower, want: "ken jeong!"},
+	{name: "title", input: "hELLO mr. chow", mode: ModeTitle, want: "Hello Mr. Chow"},
+	{name: "sentence", input: "WHAT happened? the TIGER is in the bathroom. wolfpack!", mode: ModeSentence, want: "What happened? The tiger is in the bathroom. Wolfpack!"},
+	{name: "snake", input: "Ken Jeong, Mr. Chow", mode: ModeSnake, want: "ken_jeong_mr_chow"},
+	{name: "kebab", input: "Ken Jeong, Mr. Chow", mode: ModeKebab, want: "ken-jeong-mr-chow"},
+	{name: "camel", input: "Ken Jeong, Mr. Chow", mode: ModeCamel, want: "kenJeongMrChow"},
+	{name: "pascal", input: "ken jeong, mr. chow", mode: ModePascal, want: "KenJeongMrChow"},
+	{name: "snake from camel", input: "helloWorld", mode: ModeSnake, want: "hello_world"},
+	{name: "snake from acronym", input: "HTTPServer", mode: ModeSnake, want: "http_server"},
+	{name: "kebab from pascal", input: "ParseHTTPRequest", mode: ModeKebab, want: "parse-http-request"},
+	{name: "empty", input: "", mode: ModeCamel, want: ""},
+	{name: "only separators", input: " -_- ", mode: ModeSnake, want: ""},
+
+	// Turkish has a dotted and a dotless i in both cases
+	{name: "turkish upper", input: "istanbul ılık", mode: ModeUpper, locale: "tr", want: "İSTANBUL ILIK"},
+	{name: "turkish lower", input: "İSTANBUL ILIK", mode: ModeLower, locale: "tr", want: "istanbul ılık"},
+	{name: "turkish title", input: "istanbul izmir", mode: ModeTitle, locale: "tr", want: "İstanbul İzmir"},
+	{name: "turkish pascal", input: "iyi ılık", mode: ModePascal, locale: "tr", want: "İyiIlık"},
+	{name: "i without locale", input: "istanbul", mode: ModeUpper, want: "ISTANBUL"},
+	{name: "I without locale", input: "ILIK", mode: ModeLower, want: "ilik"},
+
+	// German ß has no single upper case letter
+	{name: "german upper", input: "Straße", mode: ModeUpper, locale: "de", want: "STRASSE"},
+	{name: "german snake", input: "Große Straße", mode: ModeSnake, locale: "de", want: "große_straße"},
+
+	// e followed by U+0301 COMBINING ACUTE ACCENT is normalized to é
+	{name: "combining mark upper", input: "cafe\u0301", mode: ModeUpper, want: "CAF\u00c9"},
+	{name: "combining mark camel", input: "cafe\u0301 noir", mode: ModeCamel, want: "caf\u00e9Noir"},
+	// q has no precomposed form, so the mark stays attached to it
+	{name: "mark without precomposed form", input: "aq\u0307 bq\u0307", mode: ModePascal, want: "Aq\u0307Bq\u0307"},
+	{name: "mark does not split words", input: "q\u0307Case", mode: ModeSnake, want: "q\u0307_case"},
+
+	{name: "greek final sigma", input: "ΟΔΟΣ ΟΔΟΣ", mode: ModeLower, locale: "el", want: "οδος οδος"},
+}
+
+func TestTransform(t *testing.T) {
+	for _, tc := range transformCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// act
+			got, err := Transform(tc.input, tc.mode, tc.locale)
+
+			// assert
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != tc.want {
+				t.Errorf("Transform(%q, %v, %q): got=%q, want=%q", tc.input, tc.mode, tc.locale, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTransformErrors(t *testing.T) {
+	if _, err := Transform("crab", "shout", ""); !errors.Is(err, ErrUnknownMode) {
+		t.Errorf("unknown mode: got=%v, want=%v", err, ErrUnknownMode)
+	}
+
+	if _, err := Transform("crab", ModeUpper, "not a locale!"); !errors.Is(err, ErrInvalidLocale) {
+		t.Errorf("invalid locale: got=%v, want=%v", err, ErrInvalidLocale)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  Mode
+		err   error
+	}{
+		{input: "", want: ModeUpper},
+		{input: "snake", want: ModeSnake},
+		{input: "PASCAL", want: ModePascal},
+		{input: "shout", err: ErrUnknownMode},
+	}
+
+	for _, tc := range testCases {
+		got, err := ParseMode(tc.input)
+
+		if got != tc.want || !errors.Is(err, tc.err) {
+			t.Errorf("ParseMode(%q): got=(%v, %v), want=(%v, %v)", tc.input, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  []string
+	}{
+		{input: "hello world", want: []string{"hello", "world"}},
+		{input: "helloWorld", want: []string{"hello", "World"}},
+		{input: "HTTPServer", want: []string{"HTTP", "Server"}},
+		{input: "snake_case-and-kebab", want: []string{"snake", "case", "and", "kebab"}},
+		{input: "version2 release", want: []string{"version2", "release"}},
+		{input: "ÇokGüzel", want: []string{"Çok", "Güzel"}},
+	}
+
+	for _, tc := range testCases {
+		if got := splitWords(tc.input); !slices.Equal(got, tc.want) {
+			t.Errorf("splitWords(%q): got=%q, want=%q", tc.input, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxPending bounds the input a Writer holds back while it waits for the end
+// of a word. A single "word" longer than this is converted in parts, see
+// forcedCut.
+const maxPending = 64 << 10
+
+var errWriterClosed = errors.New("app: write to closed Writer")
+
+// Writer applies a Mode to text that arrives in pieces and writes the result
+// to an underlying io.Writer, so that arbitrarily large input can be
+// transformed in constant memory. Pieces may end in the middle of a UTF-8
+// sequence or a word: the Writer only converts up to the last whitespace and
+// keeps the rest until more input arrives or Close is called. The output is
+// the same as Transform would produce for the concatenated input, unless a
+// run of more than 64 KiB without whitespace has no two letters in a row
+// where it can be split.
+type Writer struct {
+	w    io.Writer
+	mode Mode
+
+	upper cases.Caser
+	lower cases.Caser
+	title cases.Caser
+
+	pending []byte
+
+	// buffers reused for every segment
+	normalized []byte
+	lowered    []byte
+	out        []byte
+
+	// carried from one segment to the next
+	capitalizeNext bool     // ModeSentence: the next letter starts a sentence
+	words          int      // word modes: number of words written so far
+	tail           wordTail // word modes: the word the last segment ended in
+	midWord        bool     // the last segment was cut between two letters
+
+	closed bool
+}
+
+// NewWriter returns a Writer that writes text converted according to mode
+// and locale to w. It fails like Transform on an unknown mode or locale.
+func NewWriter(w io.Writer, mode Mode, locale string) (*Writer, error) {
+	tag, err := ParseLocale(locale)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !mode.valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMode, mode)
+	}
+
+	return &Writer{
+		w:              w,
+		mode:           mode,
+		upper:          cases.Upper(tag),
+		lower:          cases.Lower(tag),
+		title:          cases.Title(tag),
+		capitalizeNext: true,
+	}, nil
+}
+
+// Write converts p up to its last safe boundary. It always consumes all of p
+// unless the underlying writer fails.
+func (tw *Writer) Write(p []byte) (int, error) {
+	if tw.closed {
+		return 0, errWriterClosed
+	}
+
+	// what was held back before contains no boundary, except maybe at its
+	// very end where the next rune was still missing
+	from := max(len(tw.pending)-utf8.UTFMax, 0)
+	tw.pending = append(tw.pending, p...)
+	cut := safeCut(tw.pending, from)
+	midWord := false
+
+	if cut == 0 && len(tw.pending) > maxPending {
+		cut, midWord = forcedCut(tw.pending)
+	}
+
+	if cut == 0 {
+		return len(p), nil
+	}
+
+	err := tw.writeSegment(tw.pending[:cut])
+	tw.pending = tw.pending[:copy(tw.pending, tw.pending[cut:])]
+	tw.midWord = midWord
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close converts the input held back so far. It does not close the
+// underlying writer.
+func (tw *Writer) Close() error {
+	if tw.closed {
+		return nil
+	}
+
+	tw.closed = true
+	err := tw.writeSegment(tw.pending)
+	tw.pending = nil
+
+	return err
+}
+
+// safeCut returns the end of the longest prefix of p that can be converted
+// on its own: it ends with whitespace and the next rune is complete and not a
+// combining mark that could change the rune before it. Only prefixes ending
+// after from are considered; 0 means there is none.
+func safeCut(p []byte, from int) int {
+	end := len(p)
+
+	for end > from {
+		r, size := utf8.DecodeLastRune(p[:end])
+
+		if unicode.IsSpace(r) && end < len(p) && utf8.FullRune(p[end:]) {
+			if next, _ := utf8.DecodeRune(p[end:]); !unicode.IsMark(next) {
+				return end
+			}
+		}
+
+		end -= size
+	}
+
+	return 0
+}
+
+// lastRuneBoundary returns the start of the last rune in p, so that an
+// incomplete UTF-8 sequence at the end is never split.
+func lastRuneBoundary(p []byte) int {
+	start := len(p) - 1
+
+	for start > 0 && start > len(p)-utf8.UTFMax && !utf8.RuneStart(p[start]) {
+		start--
+	}
+
+	if utf8.FullRune(p[start:]) {
+		return len(p)
+	}
+
+	return start
+}
+
+// forcedCut returns where to split p, which has grown past maxPending without
+// a safe cut, and reports whether the cut lies between two letters. It takes
+// the last place between two cased letters where the split changes nothing:
+// the second letter cannot combine with the first, it is not the lower case
+// letter after an upper case one that may start a word as in "HTTPServer",
+// and neither is a capital sigma, whose lower case depends on its
+// neighbours. Without such a place it only keeps the last rune and its
+// combining marks, which may still be incomplete.
+func forcedCut(p []byte) (int, bool) {
+	end := lastRuneBoundary(p)
+	fallback := 0
+
+	// the last complete rune may still get combining marks
+	_, size := utf8.DecodeLastRune(p[:end])
+
+	for cut := end - size; cut > 0; cut -= size {
+		var prev rune
+		prev, size = utf8.DecodeLastRune(p[:cut])
+		next, _ := utf8.DecodeRune(p[cut:])
+
+		if !norm.NFC.Properties(p[cut:]).BoundaryBefore() {
+			continue
+		}
+
+		if fallback == 0 {
+			fallback = cut
+		}
+
+		if isCased(prev) && isCased(next) && prev != 'Σ' && next != 'Σ' &&
+			!(unicode.IsUpper(prev) && unicode.IsLower(next)) {
+			return cut, true
+		}
+	}
+
+	if fallback == 0 {
+		// nothing but combining marks
+		return end, false
+	}
+
+	return fallback, false
+}
+
+// isCased reports whether r is an upper, lower or title case letter.
+func isCased(r rune) bool {
+	return unicode.IsUpper(r) || unicode.IsLower(r) || unicode.IsTitle(r)
+}
+
+// writeSegment converts a piece of text that ends on a safe boundary.
+func (tw *Writer) writeSegment(text []byte) error {
+	if len(text) == 0 {
+		return nil
+	}
+
+	tw.normalized = norm.NFC.Append(tw.normalized[:0], text...)
+	text = tw.normalized
+	out := tw.out[:0]
+
+	switch tw.mode {
+	case ModeUpper:
+		out = appendCased(out, tw.upper, text)
+	case ModeLower:
+		out = appendCased(out, tw.lower, text)
+	case ModeTitle:
+		if tw.midWord {
+			out = appendContinued(out, tw.title, text)
+		} else {
+			out = appendCased(out, tw.title, text)
+		}
+	case ModeSentence:
+		out = tw.appendSentenceCase(out, text)
+	case ModeSnake:
+		out = tw.appendWords(out, text, "_", false, false)
+	case ModeKebab:
+		out = tw.appendWords(out, text, "-", false, false)
+	case ModeCamel:
+		out = tw.appendWords(out, text, "", false, true)
+	case ModePascal:
+		out = tw.appendWords(out, text, "", true, true)
+	}
+
+	tw.out = out
+	_, err := tw.w.Write(out)
+
+	return err
+}
+
+// appendCased appends src converted by c to dst.
+func appendCased(dst []byte, c cases.Caser, src []byte) []byte {
+	c.Reset()
+
+	for {
+		// upper casing may grow the text, e.g. ß becomes SS
+		dst = slices.Grow(dst, len(src)+utf8.UTFMax)
+		nDst, nSrc, err := c.Transform(dst[len(dst):cap(dst)], src, true)
+		dst = dst[:len(dst)+nDst]
+		src = src[nSrc:]
+
+		if err != transform.ErrShortDst {
+			return dst
+		}
+	}
+}
+
+// appendContinued is appendCased for text that carries on a word after a
+// cased letter: c sees a lower case letter in front of text, so that it does
+// not take the first letter of text for the start of a word.
+func appendContinued(dst []byte, c cases.Caser, text []byte) []byte {
+	n := len(dst)
+	dst = appendCased(dst, c, append([]byte{'a'}, text...))
+
+	// every Caser maps 'a' to a single byte
+	return append(dst[:n], dst[n+1:]...)
+}
+
+// appendSentenceCase appends text in lower case, with the first letter of
+// every sentence in upper case, i.e. the first letter of the stream and the
+// first letter after a '.', '!' or '?'.
+func (tw *Writer) appendSentenceCase(dst []byte, text []byte) []byte {
+	tw.lowered = appendCased(tw.lowered[:0], tw.lower, text)
+
+	for i := 0; i < len(tw.lowered); {
+		r, size := utf8.DecodeRune(tw.lowered[i:])
+
+		switch {
+		case tw.capitalizeNext && unicode.IsLetter(r):
+			dst = appendCased(dst, tw.upper, tw.lowered[i:i+size])
+			tw.capitalizeNext = false
+		case r == '.' || r == '!' || r == '?':
+			dst = append(dst, tw.lowered[i:i+size]...)
+			tw.capitalizeNext = true
+		default:
+			dst = append(dst, tw.lowered[i:i+size]...)
+		}
+
+		i += size
+	}
+
+	return dst
+}
+
+// appendWords splits text into words and appends them joined with sep.
+// Words are lower case, except that titled words start with an upper case
+// letter; the first word of the whole stream is titled only if titleFirst is
+// set.
+func (tw *Writer) appendWords(dst []byte, text []byte, sep string, titleFirst, titleRest bool) []byte {
+	words, continued := splitWordsAfter(string(text), &tw.tail)
+
+	for i, word := range words {
+		if i == 0 && continued {
+			// the rest of the word the last segment was cut in
+			dst = appendCased(dst, tw.lower, []byte(word))
+			continue
+		}
+
+		if tw.words > 0 {
+			dst = append(dst, sep...)
+		}
+
+		if (tw.words == 0 && titleFirst) || (tw.words > 0 && titleRest) {
+			dst = appendCased(dst, tw.title, []byte(word))
+		} else {
+			dst = appendCased(dst, tw.lower, []byte(word))
+		}
+
+		tw.words++
+	}
+
+	return dst
+}
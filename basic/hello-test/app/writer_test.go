@@ -0,0 +1,177 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// writeInChunks feeds input to a Writer size bytes at a time, so that the
+// pieces end in the middle of words and UTF-8 sequences.
+func writeInChunks(input string, mode Mode, locale string, size int) (string, error) {
+	var b strings.Builder
+	tw, err := NewWriter(&b, mode, locale)
+
+	if err != nil {
+		return "", err
+	}
+
+	for start := 0; start < len(input); start += size {
+		end := min(start+size, len(input))
+
+		if _, err := tw.Write([]byte(input[start:end])); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+func TestWriterMatchesTransform(t *testing.T) {
+	for _, tc := range transformCases {
+		for _, size := range []int{1, 2, 3, 5, 64} {
+			t.Run(fmt.Sprintf("%v/%v bytes", tc.name, size), func(t *testing.T) {
+				// act
+				got, err := writeInChunks(tc.input, tc.mode, tc.locale, size)
+
+				// assert
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if got != tc.want {
+					t.Errorf("got=%q, want=%q", got, tc.want)
+				}
+			})
+		}
+	}
+}
+
+func TestWriterStateAcrossSegments(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		mode  Mode
+		want  string
+	}{
+		{name: "sentence", input: "one. two three four. five", mode: ModeSentence, want: "One. Two three four. Five"},
+		{name: "camel", input: "one two three four five", mode: ModeCamel, want: "oneTwoThreeFourFive"},
+		{name: "snake", input: "one two three four five", mode: ModeSnake, want: "one_two_three_four_five"},
+		{name: "combining mark after a space", input: "a \u0301b c", mode: ModeUpper, want: "A \u0301B C"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// act
+			got, err := writeInChunks(tc.input, tc.mode, "", 4)
+
+			// assert
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != tc.want {
+				t.Errorf("got=%q, want=%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriterBoundsPendingInput(t *testing.T) {
+	// asset
+	word := strings.Repeat("ğ", maxPending) // two bytes each, no whitespace
+	var b strings.Builder
+	tw, _ := NewWriter(&b, ModeUpper, "")
+
+	// act
+	for i := 0; i < len(word); i += 999 {
+		tw.Write([]byte(word[i:min(i+999, len(word))]))
+	}
+
+	held := len(tw.pending)
+	tw.Close()
+
+	// assert
+	if held > maxPending+999 {
+		t.Errorf("Writer held back %v bytes, want at most %v", held, maxPending+999)
+	}
+
+	if want := strings.Repeat("Ğ", maxPending); b.String() != want {
+		t.Errorf("a word longer than the pending limit was not converted intact")
+	}
+}
+
+func TestWriterLongWordMatchesTransform(t *testing.T) {
+	// asset
+	// one word of twice the pending limit, with case changes, a capital sigma
+	// and combining marks wherever the Writer may have to cut it
+	input := "hello " + strings.Repeat("crabHTTPServerΣé\u0301x", 2*maxPending/24) + " world"
+
+	for _, mode := range []Mode{ModeUpper, ModeLower, ModeTitle, ModeSentence, ModeSnake, ModeKebab, ModeCamel, ModePascal} {
+		for _, size := range []int{999, 4096} {
+			t.Run(fmt.Sprintf("%v/%v bytes", mode, size), func(t *testing.T) {
+				want, _ := Transform(input, mode, "")
+
+				// act
+				got, err := writeInChunks(input, mode, "", size)
+
+				// assert
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if got != want {
+					t.Errorf("the word was changed where the Writer cut it")
+				}
+			})
+		}
+	}
+}
+
+func TestForcedCut(t *testing.T) {
+	testCases := []struct {
+		name       string
+		input      string
+		want       string // what is written, the rest is held back
+		wantInWord bool
+	}{
+		{name: "between letters", input: "abcd", want: "abc", wantInWord: true},
+		{name: "before combining marks", input: "abc\u0301\u0302", want: "ab", wantInWord: true},
+		{name: "lower case letters", input: "HTTPServer", want: "HTTPServe", wantInWord: true},
+		{name: "not into the upper case letter starting a word", input: "HTTPSe", want: "HTTP", wantInWord: true},
+		{name: "not next to a capital sigma", input: "ΑΒΣΣΑ", want: "Α", wantInWord: true},
+		{name: "digits", input: "12345", want: "1234", wantInWord: false},
+		{name: "only capital sigmas", input: "ΣΣΣ", want: "ΣΣ", wantInWord: false},
+		{name: "incomplete rune", input: "abc\xc4", want: "ab", wantInWord: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// act
+			cut, inWord := forcedCut([]byte(tc.input))
+
+			// assert
+			if got := tc.input[:cut]; got != tc.want || inWord != tc.wantInWord {
+				t.Errorf("got=%q, %v, want=%q, %v", got, inWord, tc.want, tc.wantInWord)
+			}
+		})
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	if _, err := NewWriter(&strings.Builder{}, "", ""); !errors.Is(err, ErrUnknownMode) {
+		t.Errorf("empty mode: got=%v, want=%v", err, ErrUnknownMode)
+	}
+
+	tw, _ := NewWriter(&strings.Builder{}, ModeLower, "")
+	tw.Close()
+
+	if _, err := tw.Write([]byte("crab")); err == nil {
+		t.Errorf("Write after Close succeeded")
+	}
+}
@@ -1,3 +1,8 @@
 module hello-test
 
 go 1.24.4
+
+require (
+	golang.org/x/net v0.39.0
+	golang.org/x/text v0.24.0
+)
@@ -1,14 +1,214 @@
 package main
 
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"hello-test/server"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// Exit codes of the binary.
+const (
+	exitOK    = 0
+	exitError = 1 // the server could not start or stopped with an error
+	exitUsage = 2 // bad command line or environment
+)
+
+const usage = `Usage: hello-test <command> [flags]
+
+Commands:
+  serve     start the server
+  routes    list the registered endpoints
+  version   print the version
+
+Run "hello-test <command> -h" for the flags of a command.
+`
+
 func main() {
+	os.Exit(Run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// Run executes the command line args and returns the process exit code.
+func Run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "serve":
+		return serve(args[1:], stderr)
+	case "routes":
+		return routes(args[1:], stdout, stderr)
+	case "version":
+		fmt.Fprintf(stdout, "hello-test %v (%v)\n", version, runtime.Version())
+		return exitOK
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%v", args[0], usage)
+		return exitUsage
+	}
+}
+
+// config is what the serve command needs to build the server.
+type config struct {
+	protocol string
+	port     int
+	addr     string
+	h2c      bool
+	logLevel slog.Level
+}
+
+// parseServeFlags reads the flags shared by the serve and routes commands.
+// Every flag falls back to an environment variable, and then to a default.
+func parseServeFlags(command string, args []string, stderr io.Writer) (config, error) {
+	var cfg config
+
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	flags.StringVar(&cfg.protocol, "protocol", env("GOING_CRAB_PROTOCOL", "tcp"), "network to listen on: tcp, tcp4, tcp6 or unix (env GOING_CRAB_PROTOCOL)")
+	port := flags.String("port", env("GOING_CRAB_PORT", "8080"), "port to listen on (env GOING_CRAB_PORT)")
+	flags.StringVar(&cfg.addr, "addr", env("GOING_CRAB_ADDR", ""), "address or unix socket path, overrides -port (env GOING_CRAB_ADDR)")
+	h2c, h2cErr := strconv.ParseBool(env("GOING_CRAB_H2C", "false"))
+	flags.BoolVar(&cfg.h2c, "h2c", h2c, "also serve HTTP/2 without TLS, with prior knowledge or Upgrade: h2c (env GOING_CRAB_H2C)")
+	logLevel := flags.String("log-level", env("GOING_CRAB_LOG_LEVEL", "info"), "debug, info, warn or error (env GOING_CRAB_LOG_LEVEL)")
+
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if flags.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	var err error
+
+	if cfg.port, err = strconv.Atoi(*port); err != nil || cfg.port < 0 || cfg.port > 65535 {
+		return cfg, fmt.Errorf("invalid port %q", *port)
+	}
+
+	if h2cErr != nil {
+		return cfg, fmt.Errorf("invalid GOING_CRAB_H2C %q", os.Getenv("GOING_CRAB_H2C"))
+	}
+
+	if err := cfg.logLevel.UnmarshalText([]byte(*logLevel)); err != nil {
+		return cfg, fmt.Errorf("invalid log level %q", *logLevel)
+	}
+
+	return cfg, nil
+}
+
+func env(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
 
+	return fallback
 }
 
-func Run() {
-	// srv := new("tcp", 8080)
+// newServer wires the endpoints of the application.
+func newServer(cfg config, logger *log.Logger) (*server.Server, error) {
+	opts := []server.Option{server.WithLogger(logger)}
+
+	if cfg.addr != "" {
+		opts = append(opts, server.WithAddr(cfg.addr))
+	}
+
+	if cfg.h2c {
+		opts = append(opts, server.WithH2C())
+	}
+
+	srv := server.New(cfg.protocol, cfg.port, opts...)
+
+	if err := srv.RegisterEndpoint("POST /capitalize", http.HandlerFunc(server.CapitalizeHandler(logger))); err != nil {
+		return nil, err
+	}
+
+	if err := srv.RegisterEndpoint("POST /capitalize/batch", http.HandlerFunc(server.BatchHandler(logger))); err != nil {
+		return nil, err
+	}
+
+	return srv, nil
+}
+
+// serverLogger bridges the log.Logger of the server and its handlers to
+// handler. The lines carry no level: most are about a client gone wrong, e.g.
+// a failed TLS handshake or an unreadable body, and the rest are notes about
+// shutting down, so they are logged as warnings. -log-level error hides them
+// and keeps only what made the server itself fail.
+func serverLogger(handler slog.Handler) *log.Logger {
+	return slog.NewLogLogger(handler, slog.LevelWarn)
+}
+
+func serve(args []string, stderr io.Writer) int {
+	cfg, err := parseServeFlags("serve", args, stderr)
+
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	handler := slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: cfg.logLevel})
+	logger := slog.New(handler)
+	srv, err := newServer(cfg, serverLogger(handler))
+
+	if err != nil {
+		logger.Error("cannot set up the server", "error", err)
+		return exitError
+	}
+
+	logger.Info("starting server", "protocol", cfg.protocol, "port", cfg.port, "addr", cfg.addr, "version", version)
+
+	if err := srv.Run(context.Background()); err != nil {
+		logger.Error("server stopped", "error", err)
+		return exitError
+	}
+
+	logger.Info("server stopped")
+
+	return exitOK
+}
+
+func routes(args []string, stdout, stderr io.Writer) int {
+	cfg, err := parseServeFlags("routes", args, stderr)
+
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	srv, err := newServer(cfg, log.New(io.Discard, "", 0))
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
 
-	// srv.registerEndpoint("/capitalize", server.)
+	for _, route := range srv.Routes() {
+		fmt.Fprintln(stdout, route)
+	}
 
-	// err := srv.run()
-	// srv.logger.Fatalf("server shutdown: %v", err)
+	return exitOK
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"hello-test/server/servertest"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	// a port that is already taken makes serve fail right away
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("unexpected listen error: %v", err)
+	}
+
+	defer busy.Close()
+
+	testCases := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{name: "no command", args: nil, code: exitUsage, stderr: "Usage:"},
+		{name: "unknown command", args: []string{"fly"}, code: exitUsage, stderr: `unknown command "fly"`},
+		{name: "help", args: []string{"help"}, code: exitOK, stdout: "Usage:"},
+		{name: "version", args: []string{"version"}, code: exitOK, stdout: "hello-test dev"},
+		{name: "routes", args: []string{"routes"}, code: exitOK, stdout: "GET /livez\nGET /readyz\nPOST /capitalize\nPOST /capitalize/batch\n"},
+		{name: "serve with unknown flag", args: []string{"serve", "-colour"}, code: exitUsage, stderr: "flag provided but not defined"},
+		{name: "serve with bad port", args: []string{"serve", "-port", "crab"}, code: exitUsage, stderr: `invalid port "crab"`},
+		{
+			name:   "serve with bad log level from env",
+			args:   []string{"serve"},
+			env:    map[string]string{"GOING_CRAB_LOG_LEVEL": "loud"},
+			code:   exitUsage,
+			stderr: `invalid log level "loud"`,
+		},
+		{
+			name:   "serve with bad h2c from env",
+			args:   []string{"serve"},
+			env:    map[string]string{"GOING_CRAB_H2C": "maybe"},
+			code:   exitUsage,
+			stderr: `invalid GOING_CRAB_H2C "maybe"`,
+		},
+		{
+			name:   "serve on a busy address",
+			args:   []string{"serve", "-addr", busy.Addr().String()},
+			code:   exitError,
+			stderr: "address already in use",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+
+			var stdout, stderr bytes.Buffer
+
+			// act
+			code := Run(tc.args, &stdout, &stderr)
+
+			// assert
+			if code != tc.code {
+				t.Errorf("exit code: got=%v, want=%v (stderr: %v)", code, tc.code, stderr.String())
+			}
+
+			if !strings.Contains(stdout.String(), tc.stdout) {
+				t.Errorf("stdout: got=%q, want it to contain %q", stdout.String(), tc.stdout)
+			}
+
+			if !strings.Contains(stderr.String(), tc.stderr) {
+				t.Errorf("stderr: got=%q, want it to contain %q", stderr.String(), tc.stderr)
+			}
+		})
+	}
+}
+
+func TestServerLoggerLevel(t *testing.T) {
+	testCases := []struct {
+		level  slog.Level
+		logged bool
+	}{
+		{level: slog.LevelInfo, logged: true},
+		{level: slog.LevelWarn, logged: true},
+		{level: slog.LevelError, logged: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.level.String(), func(t *testing.T) {
+			// asset
+			var stderr bytes.Buffer
+			handler := slog.NewTextHandler(&stderr, &slog.HandlerOptions{Level: tc.level})
+
+			// act
+			serverLogger(handler).Printf("http: TLS handshake error from %v: EOF", "192.0.2.1:1234")
+
+			// assert
+			got := stderr.String()
+
+			if logged := strings.Contains(got, "TLS handshake error"); logged != tc.logged {
+				t.Fatalf("logged=%v, want=%v: %q", logged, tc.logged, got)
+			}
+
+			if tc.logged && !strings.Contains(got, "level=WARN") {
+				t.Errorf("got=%q, want a warning", got)
+			}
+		})
+	}
+}
+
+func TestServerEndToEnd(t *testing.T) {
+	// asset
+	cfg := config{protocol: "tcp", addr: "127.0.0.1:0"}
+	srv, err := newServer(cfg, log.New(io.Discard, "", 0))
+
+	if err != nil {
+		t.Fatalf("unexpected newServer error: %v", err)
+	}
+
+	ts := servertest.Start(t, srv)
+
+	// act
+	response, err := ts.Client.Post(ts.URL+"/capitalize?mode=title", "text/plain", strings.NewReader("ken jeong"))
+
+	// assert
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+
+	if got, want := string(body), "Ken Jeong"; got != want {
+		t.Errorf("got=%v, want=%v", got, want)
+	}
+}
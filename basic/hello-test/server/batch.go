@@ -0,0 +1,401 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hello-test/app"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+)
+
+const mediaNDJSON = "application/x-ndjson"
+
+// WithBatchConcurrency limits how many items of a batch are transformed at
+// the same time. It also bounds how many finished items wait for an earlier,
+// slower one before they can be written.
+func WithBatchConcurrency(n int) HandlerOption {
+	return func(cfg *handlerConfig) {
+		if n > 0 {
+			cfg.concurrency = n
+		}
+	}
+}
+
+// batchItem is one entry of a batch: either a plain JSON string or an
+// object whose mode and lang override the query parameters.
+type batchItem struct {
+	Text *string `json:"text"`
+	Mode string  `json:"mode"`
+	Lang string  `json:"lang"`
+}
+
+// batchResult is written for every item, in the order of the input.
+type batchResult struct {
+	Index int     `json:"index"`
+	Text  *string `json:"text,omitempty"`
+	Error string  `json:"error,omitempty"`
+}
+
+// BatchHandler transforms many texts in one request. The body is either a
+// JSON array or newline-delimited JSON (application/x-ndjson), and every
+// item is a string or an object {"text": "...", "mode": "...", "lang": "..."}.
+// The query parameters mode and lang apply to items that do not set their
+// own.
+//
+// Results are streamed back in the format of the request and in the order of
+// the items, each as {"index": 0, "text": "..."} or {"index": 0, "error":
+// "..."}; an invalid item does not fail the others. Once the first result is
+// out, a body that turns out to be malformed or too large ends the stream
+// with a last error entry instead of an error status.
+func BatchHandler(logger *log.Logger, opts ...HandlerOption) func(http.ResponseWriter, *http.Request) {
+	cfg := newHandlerConfig(opts)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		mode, err := app.ParseMode(query.Get("mode"))
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		lang := query.Get("lang")
+
+		if _, err := app.ParseLocale(lang); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if r.ContentLength > cfg.maxBodyBytes {
+			http.Error(w, fmt.Sprintf("the request body is larger than the limit of %v bytes", cfg.maxBodyBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, cfg.maxBodyBytes)
+		defer r.Body.Close()
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var items batchDecoder
+
+		switch mediaType {
+		case mediaJSON:
+			items, err = newArrayDecoder(r.Body)
+		case mediaNDJSON:
+			items = newLineDecoder(r.Body)
+		default:
+			http.Error(w, "the batch must be application/json or "+mediaNDJSON, http.StatusUnsupportedMediaType)
+			return
+		}
+
+		if err != nil {
+			readError(w, mediaText, logger, cfg.maxBodyBytes, err)
+			return
+		}
+
+		http.NewResponseController(w).EnableFullDuplex()
+		w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		out := newBatchWriter(w, mediaType)
+		results := transformBatch(ctx, items, mode, lang, cfg)
+
+		for {
+			var result batchResult
+			var ok bool
+
+			select {
+			case result, ok = <-results:
+			default:
+				// nothing is ready: hand the client what is there so far
+				// instead of waiting for the response buffer to fill up
+				out.flush()
+				result, ok = <-results
+			}
+
+			if !ok {
+				break
+			}
+
+			if err := out.write(result); err != nil {
+				logger.Printf("aborting batch response after %v results: %v", out.written, err)
+				// stop transformBatch before the body is left behind
+				cancel()
+				drain(results)
+				panic(http.ErrAbortHandler)
+			}
+		}
+
+		if err := out.close(); err != nil {
+			logger.Printf("error while finishing the batch response: %v", err)
+		}
+	}
+}
+
+// transformBatch decodes the items and transforms up to cfg.concurrency of
+// them at the same time. The results come out in the order of the items.
+func transformBatch(ctx context.Context, items batchDecoder, mode app.Mode, lang string, cfg handlerConfig) <-chan batchResult {
+	// every queued channel stands for one item in flight, so the capacity
+	// bounds the work and the finished results held in memory
+	queue := make(chan chan batchResult, cfg.concurrency)
+	results := make(chan batchResult)
+
+	go func() {
+		defer close(queue)
+
+		for index := 0; ; index++ {
+			raw, err := items.next()
+
+			if err == io.EOF {
+				return
+			}
+
+			done := make(chan batchResult, 1)
+
+			select {
+			case queue <- done:
+			case <-ctx.Done():
+				return
+			}
+
+			if err != nil {
+				done <- batchResult{Index: index, Error: decodeErrorMessage(err, cfg.maxBodyBytes)}
+				return
+			}
+
+			go func() {
+				done <- transformItem(index, raw, mode, lang)
+			}()
+		}
+	}()
+
+	go func() {
+		defer close(results)
+
+		for done := range queue {
+			select {
+			case results <- <-done:
+			case <-ctx.Done():
+				drain(queue)
+				return
+			}
+		}
+	}()
+
+	return results
+}
+
+func transformItem(index int, raw json.RawMessage, mode app.Mode, lang string) batchResult {
+	result := batchResult{Index: index}
+	var item batchItem
+
+	if !json.Valid(raw) {
+		result.Error = "the item is not valid JSON"
+		return result
+	}
+
+	if raw[0] == '"' {
+		item.Text = new(string)
+		json.Unmarshal(raw, item.Text)
+	} else if err := json.Unmarshal(raw, &item); err != nil {
+		result.Error = "the item must be a string or an object"
+		return result
+	}
+
+	if item.Text == nil {
+		result.Error = `the item has no field "text"`
+		return result
+	}
+
+	if item.Lang == "" {
+		item.Lang = lang
+	}
+
+	itemMode := mode
+
+	if item.Mode != "" {
+		var err error
+
+		if itemMode, err = app.ParseMode(item.Mode); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	text, err := app.Transform(*item.Text, itemMode, item.Lang)
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Text = &text
+
+	return result
+}
+
+func decodeErrorMessage(err error, limit int64) string {
+	var maxBytesErr *http.MaxBytesError
+
+	if errors.As(err, &maxBytesErr) {
+		return fmt.Sprintf("the request body is larger than the limit of %v bytes", limit)
+	}
+
+	return fmt.Sprintf("the batch is malformed: %v", err)
+}
+
+func drain[T any](ch <-chan T) {
+	for range ch {
+	}
+}
+
+// batchDecoder returns the raw items of a batch one by one, and io.EOF
+// after the last one.
+type batchDecoder interface {
+	next() (json.RawMessage, error)
+}
+
+type arrayDecoder struct {
+	dec *json.Decoder
+}
+
+// newArrayDecoder reads the opening bracket of a JSON array, so that a body
+// that is not an array at all is rejected before the response starts.
+func newArrayDecoder(body io.Reader) (*arrayDecoder, error) {
+	dec := json.NewDecoder(body)
+	tok, err := dec.Token()
+
+	if err != nil && !isReadError(err) {
+		return nil, badRequest("the batch is not a JSON array: %v", err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tok != json.Delim('[') {
+		return nil, badRequest("the batch is not a JSON array")
+	}
+
+	return &arrayDecoder{dec: dec}, nil
+}
+
+func (ad *arrayDecoder) next() (json.RawMessage, error) {
+	if !ad.dec.More() {
+		if _, err := ad.dec.Token(); err != nil {
+			return nil, err
+		}
+
+		return nil, io.EOF
+	}
+
+	var raw json.RawMessage
+
+	if err := ad.dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	return raw, nil
+}
+
+// lineDecoder reads newline-delimited JSON. Blank lines are skipped, and a
+// malformed line is handed out as it is, to fail on its own.
+type lineDecoder struct {
+	r *bufio.Reader
+}
+
+func newLineDecoder(body io.Reader) *lineDecoder {
+	return &lineDecoder{r: bufio.NewReader(body)}
+}
+
+func (ld *lineDecoder) next() (json.RawMessage, error) {
+	for {
+		line, err := ld.r.ReadBytes('\n')
+
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, nil
+		}
+
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+	}
+}
+
+// isReadError tells errors from reading the body apart from malformed JSON.
+func isReadError(err error) bool {
+	var syntaxErr *json.SyntaxError
+
+	return !errors.As(err, &syntaxErr) && err != io.EOF && err != io.ErrUnexpectedEOF
+}
+
+// batchWriter writes results as the elements of a JSON array or as lines of
+// NDJSON.
+type batchWriter struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	array   bool
+	written int
+}
+
+func newBatchWriter(w http.ResponseWriter, mediaType string) *batchWriter {
+	return &batchWriter{w: w, rc: http.NewResponseController(w), array: mediaType == mediaJSON}
+}
+
+func (bw *batchWriter) write(result batchResult) error {
+	line, err := json.Marshal(result)
+
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case bw.array && bw.written == 0:
+		line = append([]byte("[\n"), line...)
+	case bw.array:
+		line = append([]byte(",\n"), line...)
+	default:
+		line = append(line, '\n')
+	}
+
+	if _, err := bw.w.Write(line); err != nil {
+		return err
+	}
+
+	bw.written++
+
+	return nil
+}
+
+func (bw *batchWriter) flush() {
+	if bw.written > 0 {
+		bw.rc.Flush()
+	}
+}
+
+func (bw *batchWriter) close() error {
+	if !bw.array {
+		return nil
+	}
+
+	closing := "\n]\n"
+
+	if bw.written == 0 {
+		closing = "[]\n"
+	}
+
+	_, err := io.WriteString(bw.w, closing)
+
+	return err
+}
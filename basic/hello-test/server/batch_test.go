@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchHandler(t *testing.T) {
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	handler := BatchHandler(logger, WithBatchConcurrency(2))
+
+	testCases := []struct {
+		name        string
+		target      string
+		contentType string
+		input       string
+		status      int
+		want        string
+	}{
+		{
+			name:        "json array",
+			target:      "/capitalize/batch",
+			contentType: "application/json",
+			input:       `["ken jeong", {"text": "mr chow", "mode": "title"}, {"text": "istanbul", "lang": "tr"}]`,
+			status:      http.StatusOK,
+			want:        "[\n" + `{"index":0,"text":"KEN JEONG"},` + "\n" + `{"index":1,"text":"Mr Chow"},` + "\n" + `{"index":2,"text":"İSTANBUL"}` + "\n]\n",
+		},
+		{
+			name:        "ndjson",
+			target:      "/capitalize/batch?mode=snake",
+			contentType: "application/x-ndjson",
+			input:       "\"Ken Jeong\"\n\n{\"text\": \"Mr Chow\", \"mode\": \"kebab\"}\n",
+			status:      http.StatusOK,
+			want:        `{"index":0,"text":"ken_jeong"}` + "\n" + `{"index":1,"text":"mr-chow"}` + "\n",
+		},
+		{
+			name:        "errors stay with their item",
+			target:      "/capitalize/batch",
+			contentType: "application/x-ndjson",
+			input:       "{\"text\": \"a\", \"mode\": \"shout\"}\n{nope\n42\n{\"name\": \"chow\"}\n\"\"\n",
+			status:      http.StatusOK,
+			want: `{"index":0,"error":"unknown transform mode: \"shout\""}` + "\n" +
+				`{"index":1,"error":"the item is not valid JSON"}` + "\n" +
+				`{"index":2,"error":"the item must be a string or an object"}` + "\n" +
+				`{"index":3,"error":"the item has no field \"text\""}` + "\n" +
+				`{"index":4,"text":""}` + "\n",
+		},
+		{
+			name:        "empty array",
+			target:      "/capitalize/batch",
+			contentType: "application/json",
+			input:       `[]`,
+			status:      http.StatusOK,
+			want:        "[]\n",
+		},
+		{
+			name:        "array broken in the middle",
+			target:      "/capitalize/batch",
+			contentType: "application/json",
+			input:       `["chow", }`,
+			status:      http.StatusOK,
+			want:        "[\n" + `{"index":0,"text":"CHOW"},` + "\n" + `{"index":1,"error":"the batch is malformed: invalid character ',' looking for beginning of value"}` + "\n]\n",
+		},
+		{name: "not an array", target: "/capitalize/batch", contentType: "application/json", input: `{"text": "chow"}`, status: http.StatusBadRequest},
+		{name: "unsupported type", target: "/capitalize/batch", contentType: "text/plain", input: "chow", status: http.StatusUnsupportedMediaType},
+		{name: "unknown mode", target: "/capitalize/batch?mode=shout", contentType: "application/json", input: `[]`, status: http.StatusBadRequest},
+		{name: "invalid lang", target: "/capitalize/batch?lang=x!", contentType: "application/json", input: `[]`, status: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(tc.input))
+			request.Header.Set("Content-Type", tc.contentType)
+			rw := httptest.NewRecorder()
+
+			// act
+			handler(rw, request)
+
+			// assert
+			if rw.Code != tc.status {
+				t.Fatalf("status code: got=%v, want=%v (%v)", rw.Code, tc.status, rw.Body.String())
+			}
+
+			if got := rw.Body.String(); tc.status == http.StatusOK && got != tc.want {
+				t.Errorf("response body: got=%q, want=%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBatchHandlerKeepsOrder(t *testing.T) {
+	// asset
+	const items = 1000
+	var input strings.Builder
+
+	for i := range items {
+		// vary the work per item so that they finish out of order
+		fmt.Fprintf(&input, "%q\n", strings.Repeat(fmt.Sprintf("item %v ", i), i%7*50+1))
+	}
+
+	handler := BatchHandler(log.New(io.Discard, "", log.LstdFlags), WithBatchConcurrency(8))
+	request := httptest.NewRequest(http.MethodPost, "/capitalize/batch?mode=pascal", strings.NewReader(input.String()))
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	rw := httptest.NewRecorder()
+
+	// act
+	handler(rw, request)
+
+	// assert
+	scanner := bufio.NewScanner(rw.Body)
+	scanner.Buffer(nil, 1<<20)
+	index := 0
+
+	for ; scanner.Scan(); index++ {
+		var result batchResult
+
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatalf("line %v is not JSON: %v", index, err)
+		}
+
+		want := strings.Repeat(fmt.Sprintf("Item%v", index), index%7*50+1)
+
+		if result.Index != index || result.Text == nil || *result.Text != want {
+			t.Fatalf("line %v: got=%+v, want index %v", index, result, index)
+		}
+	}
+
+	if index != items {
+		t.Errorf("number of results: got=%v, want=%v", index, items)
+	}
+}
+
+func TestBatchHandlerBodyLimit(t *testing.T) {
+	// asset
+	handler := BatchHandler(log.New(io.Discard, "", log.LstdFlags), WithMaxBodyBytes(20))
+	input := strings.NewReader("\"chow\"\n\"" + strings.Repeat("a", 100) + "\"\n")
+	// hide the length, so that the limit is only hit while streaming
+	request := httptest.NewRequest(http.MethodPost, "/capitalize/batch", io.MultiReader(input))
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	rw := httptest.NewRecorder()
+
+	// act
+	handler(rw, request)
+
+	// assert
+	want := `{"index":0,"text":"CHOW"}` + "\n" + `{"index":1,"error":"the request body is larger than the limit of 20 bytes"}` + "\n"
+
+	if got := rw.Body.String(); got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
+
+func TestBatchHandlerStreamsResults(t *testing.T) {
+	// asset
+	mux := http.NewServeMux()
+	mux.HandleFunc("/capitalize/batch", BatchHandler(log.New(io.Discard, "", log.LstdFlags)))
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	body, items := io.Pipe()
+	defer items.Close()
+
+	request, _ := http.NewRequest(http.MethodPost, testServer.URL+"/capitalize/batch", body)
+	request.Header.Set("Content-Type", "application/x-ndjson")
+
+	// act
+	responses := make(chan *http.Response, 1)
+
+	go func() {
+		response, err := testServer.Client().Do(request)
+
+		if err != nil {
+			t.Errorf("unexpected client error: %v", err)
+			close(responses)
+			return
+		}
+
+		responses <- response
+	}()
+
+	io.WriteString(items, "\"chow\"\n")
+
+	// assert
+	select {
+	case response, ok := <-responses:
+		if !ok {
+			return
+		}
+
+		defer response.Body.Close()
+		line, err := bufio.NewReader(response.Body).ReadString('\n')
+
+		if err != nil {
+			t.Fatalf("unexpected read error: %v", err)
+		}
+
+		if want := `{"index":0,"text":"CHOW"}` + "\n"; line != want {
+			t.Errorf("got=%q, want=%q", line, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the first result did not arrive while the batch was still open")
+	}
+}
@@ -1,35 +1,208 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"hello-test/app"
 	"io"
 	"log"
 	"net/http"
+	"runtime"
 )
 
-func CapitalizeHandler(logger *log.Logger) func(http.ResponseWriter, *http.Request) {
+// DefaultMaxBodyBytes is the largest request body CapitalizeHandler accepts
+// unless WithMaxBodyBytes says otherwise.
+const DefaultMaxBodyBytes = 32 << 20
+
+// chunkSize is how much of the request body is transformed at a time.
+const chunkSize = 32 << 10
+
+type handlerConfig struct {
+	maxBodyBytes int64
+	concurrency  int
+}
+
+// HandlerOption configures the endpoint handlers.
+type HandlerOption func(*handlerConfig)
+
+// WithMaxBodyBytes limits the size of the request body. Larger requests are
+// answered with 413 Request Entity Too Large.
+func WithMaxBodyBytes(n int64) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.maxBodyBytes = n
+	}
+}
+
+func newHandlerConfig(opts []HandlerOption) handlerConfig {
+	cfg := handlerConfig{maxBodyBytes: DefaultMaxBodyBytes, concurrency: runtime.GOMAXPROCS(0)}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+// CapitalizeHandler transforms the text in the request body. The query
+// parameter mode picks the conversion (see app.Mode, upper case by default)
+// and lang the language whose casing rules apply, e.g.
+// /capitalize?mode=title&lang=tr.
+//
+// The text may be sent as text/plain in any common charset, as JSON
+// {"text": "..."}, as a form field "text" or as a multipart file upload; see
+// requestText. The reply is text/plain or, if the Accept header prefers it,
+// JSON {"text": "..."}. Errors are reported in the same format.
+//
+// The body is transformed while it is read, chunk by chunk, so memory use
+// does not grow with its size.
+func CapitalizeHandler(logger *log.Logger, opts ...HandlerOption) func(http.ResponseWriter, *http.Request) {
+	cfg := newHandlerConfig(opts)
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		contentType, ok := r.Header["Content-Type"]
+		reply, ok := negotiate(r.Header.Get("Accept"), []string{mediaText, mediaJSON})
+
+		if !ok {
+			http.Error(w, "the response can only be text/plain or application/json", http.StatusNotAcceptable)
+			return
+		}
+
+		query := r.URL.Query()
+		mode, err := app.ParseMode(query.Get("mode"))
 
-		if !ok || len(contentType) == 0 || (contentType[0] != "text/plain") {
-			msg := "the header Content-Type is not text/plain"
-			logger.Println(msg)
+		if err != nil {
+			replyError(w, reply, http.StatusBadRequest, err.Error())
+			return
+		}
 
-			http.Error(w, msg, http.StatusBadRequest)
+		if r.ContentLength > cfg.maxBodyBytes {
+			tooLarge(w, reply, cfg.maxBodyBytes)
 			return
 		}
 
-		text, err := io.ReadAll(r.Body)
+		r.Body = http.MaxBytesReader(w, r.Body, cfg.maxBodyBytes)
 		defer r.Body.Close()
 
+		input, err := requestText(r)
+
 		if err != nil {
-			logger.Printf("error while reading r.Body: %v", err)
+			readError(w, reply, logger, cfg.maxBodyBytes, err)
+			return
+		}
 
-			http.Error(w, "internal server error", http.StatusInternalServerError)
+		out := &countingWriter{w: w}
+		var dst io.Writer = out
+		var jw *jsonTextWriter
+
+		if reply == mediaJSON {
+			jw = &jsonTextWriter{w: out}
+			dst = jw
+		}
+
+		tw, err := app.NewWriter(dst, mode, query.Get("lang"))
+
+		if err != nil {
+			replyError(w, reply, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		body := app.Capitalize(string(text))
-		w.Write([]byte(body))
+		// let HTTP/1.1 clients keep sending while we already answer
+		http.NewResponseController(w).EnableFullDuplex()
+		w.Header().Set("Content-Type", reply+"; charset=utf-8")
+
+		err = stream(tw, input)
+
+		if err == nil && jw != nil {
+			err = jw.Close()
+		}
+
+		switch {
+		case err != nil && out.n == 0:
+			readError(w, reply, logger, cfg.maxBodyBytes, err)
+		case err != nil:
+			// the status line is gone already; cut the response off so the
+			// client cannot mistake it for a complete one
+			logger.Printf("aborting response after %v bytes: %v", out.n, err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// readError answers a request whose body could not be read.
+func readError(w http.ResponseWriter, reply string, logger *log.Logger, limit int64, err error) {
+	var maxBytesErr *http.MaxBytesError
+	var reqErr *requestError
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		tooLarge(w, reply, limit)
+	case errors.As(err, &reqErr):
+		replyError(w, reply, reqErr.status, reqErr.msg)
+	default:
+		logger.Printf("error while reading r.Body: %v", err)
+
+		replyError(w, reply, http.StatusBadRequest, "the request body could not be read")
+	}
+}
+
+// replyError writes msg as plain text or as JSON {"error": msg}.
+func replyError(w http.ResponseWriter, reply string, status int, msg string) {
+	if reply != mediaJSON {
+		http.Error(w, msg, status)
+		return
 	}
+
+	w.Header().Set("Content-Type", mediaJSON+"; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// stream copies body through tw in chunks of chunkSize.
+func stream(tw *app.Writer, body io.Reader) error {
+	buf := make([]byte, chunkSize)
+
+	for {
+		n, readErr := body.Read(buf)
+
+		// MaxBytesReader hands out the bytes up to the limit together with
+		// the error; they must not be answered
+		var maxBytesErr *http.MaxBytesError
+
+		if errors.As(readErr, &maxBytesErr) {
+			return readErr
+		}
+
+		if n > 0 {
+			if _, err := tw.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+
+		if readErr == io.EOF {
+			return tw.Close()
+		}
+
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+func tooLarge(w http.ResponseWriter, reply string, limit int64) {
+	msg := fmt.Sprintf("the request body is larger than the limit of %v bytes", limit)
+	replyError(w, reply, http.StatusRequestEntityTooLarge, msg)
+}
+
+// countingWriter remembers whether the response has been started.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+
+	return n, err
 }
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -87,3 +88,200 @@ func TestEndpointNoServer(t *testing.T) {
 		return
 	}
 }
+
+func TestEndpointModes(t *testing.T) {
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	handler := CapitalizeHandler(logger)
+
+	testCases := []struct {
+		name   string
+		target string
+		input  string
+		status int
+		want   string
+	}{
+		{name: "default mode", target: "/capitalize", input: "Bradley Cooper", status: http.StatusOK, want: "BRADLEY COOPER"},
+		{name: "snake", target: "/capitalize?mode=snake", input: "Bradley Cooper", status: http.StatusOK, want: "bradley_cooper"},
+		{name: "turkish title", target: "/capitalize?mode=title&lang=tr", input: "istanbul", status: http.StatusOK, want: "İstanbul"},
+		{name: "unknown mode", target: "/capitalize?mode=shout", input: "Bradley Cooper", status: http.StatusBadRequest},
+		{name: "invalid lang", target: "/capitalize?lang=x!", input: "Bradley Cooper", status: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			request := httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(tc.input))
+			request.Header.Set("Content-Type", "text/plain")
+			rw := httptest.NewRecorder()
+
+			// act
+			handler(rw, request)
+
+			// assert
+			if rw.Code != tc.status {
+				t.Fatalf("status code: got=%v, want=%v", rw.Code, tc.status)
+			}
+
+			if got := rw.Body.String(); tc.status == http.StatusOK && got != tc.want {
+				t.Errorf("response body: got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEndpointBodyLimit(t *testing.T) {
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	handler := CapitalizeHandler(logger, WithMaxBodyBytes(10))
+
+	testCases := []struct {
+		name          string
+		contentLength int64
+		input         string
+		status        int
+	}{
+		{name: "within the limit", contentLength: 10, input: "ken jeong!", status: http.StatusOK},
+		{name: "declared too large", contentLength: 20, input: "ken jeong, mr. chow!", status: http.StatusRequestEntityTooLarge},
+		{name: "chunked too large", contentLength: -1, input: "ken jeong, mr. chow!", status: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			request := httptest.NewRequest(http.MethodPost, "/capitalize", strings.NewReader(tc.input))
+			request.Header.Set("Content-Type", "text/plain")
+			request.ContentLength = tc.contentLength
+			rw := httptest.NewRecorder()
+
+			// act
+			handler(rw, request)
+
+			// assert
+			if rw.Code != tc.status {
+				t.Errorf("status code: got=%v, want=%v", rw.Code, tc.status)
+			}
+
+			if tc.status == http.StatusRequestEntityTooLarge && !strings.Contains(rw.Body.String(), "limit of 10 bytes") {
+				t.Errorf("413 body does not name the limit: %q", rw.Body.String())
+			}
+		})
+	}
+}
+
+// crabReader produces size bytes of repeated text without holding them in
+// memory.
+type crabReader struct {
+	size int64
+	read int64
+}
+
+const crabText = "ken jeong, mr. chow. kaman! kachick! "
+
+func (cr *crabReader) Read(p []byte) (int, error) {
+	if cr.read >= cr.size {
+		return 0, io.EOF
+	}
+
+	n := 0
+
+	for n < len(p) && cr.read < cr.size {
+		p[n] = crabText[cr.read%int64(len(crabText))]
+		n++
+		cr.read++
+	}
+
+	return n, nil
+}
+
+func TestEndpointStreamsLargeBodies(t *testing.T) {
+	// asset
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/capitalize", CapitalizeHandler(logger, WithMaxBodyBytes(8<<20)))
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	size := int64(4 << 20)
+	want := strings.ToUpper(crabText)
+
+	// act
+	response, err := testServer.Client().Post(testServer.URL+"/capitalize", "text/plain", &crabReader{size: size})
+
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+
+	defer response.Body.Close()
+
+	// assert
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status code: got=%v, want=%v", response.StatusCode, http.StatusOK)
+	}
+
+	got, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+
+	if int64(len(got)) != size {
+		t.Errorf("response size: got=%v, want=%v", len(got), size)
+	}
+
+	if !strings.HasPrefix(string(got), want+want) || !strings.Contains(string(got[size/2:]), want) {
+		t.Errorf("response is not the upper-cased input")
+	}
+}
+
+func TestEndpointAbortsWhenLimitIsHitMidStream(t *testing.T) {
+	// asset
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/capitalize", CapitalizeHandler(logger, WithMaxBodyBytes(1<<20)))
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	// act
+	response, err := testServer.Client().Post(testServer.URL+"/capitalize", "text/plain", &crabReader{size: 4 << 20})
+
+	// assert
+	if err != nil {
+		return // the server may already have hung up while we were sending
+	}
+
+	defer response.Body.Close()
+
+	if _, err := io.ReadAll(response.Body); err == nil {
+		t.Errorf("a response cut off at the body limit looked complete")
+	}
+}
+
+// discardResponseWriter throws the response away, so benchmarks measure only
+// the handler.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (d *discardResponseWriter) WriteHeader(int)             {}
+
+// BenchmarkCapitalizeHandler shows that the memory used per request (B/op)
+// stays flat while the body grows.
+func BenchmarkCapitalizeHandler(b *testing.B) {
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	handler := CapitalizeHandler(logger, WithMaxBodyBytes(1<<30))
+
+	for _, size := range []int64{1 << 20, 16 << 20, 64 << 20} {
+		b.Run(fmt.Sprintf("%vMiB", size>>20), func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				request := httptest.NewRequest(http.MethodPost, "/capitalize", &crabReader{size: size})
+				request.Header.Set("Content-Type", "text/plain")
+
+				handler(&discardResponseWriter{header: http.Header{}}, request)
+			}
+		})
+	}
+}
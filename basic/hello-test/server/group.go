@@ -0,0 +1,104 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Group registers endpoints below a shared path prefix, wrapped in the
+// middleware of the group and of every group it is nested in. The patterns
+// are registered on the server with the prefix applied, so matching,
+// methods and wildcards behave exactly as for Server.RegisterEndpoint.
+type Group struct {
+	server     *Server
+	parent     *Group
+	prefix     string
+	middleware []Middleware
+}
+
+// Group returns a group for the endpoints below prefix, e.g. "/v1/admin".
+func (ws *Server) Group(prefix string) *Group {
+	return &Group{server: ws, prefix: cleanPrefix(prefix)}
+}
+
+// Group returns a nested group whose prefix is appended to g's prefix.
+func (g *Group) Group(prefix string) *Group {
+	return &Group{server: g.server, parent: g, prefix: g.prefix + cleanPrefix(prefix)}
+}
+
+// Use adds middleware to every endpoint registered on g or its nested groups
+// from now on. Group middleware runs after the global middleware and before
+// the endpoint's own; outer groups run before inner ones.
+func (g *Group) Use(mws ...Middleware) {
+	g.middleware = append(g.middleware, mws...)
+}
+
+// RegisterEndpoint registers pattern below the group's prefix. The pattern
+// may carry a method and a host like any ServeMux pattern: on a "/v1" group,
+// "POST /capitalize" becomes "POST /v1/capitalize".
+func (g *Group) RegisterEndpoint(pattern string, handler http.Handler, mws ...Middleware) error {
+	prefixed, err := prefixPattern(g.prefix, pattern)
+
+	if err != nil {
+		return fmt.Errorf("register %q: %w", pattern, err)
+	}
+
+	return g.server.RegisterEndpoint(prefixed, handler, g.chainWith(mws)...)
+}
+
+// UnregisterEndpoint removes an endpoint registered on g with pattern.
+func (g *Group) UnregisterEndpoint(pattern string) error {
+	prefixed, err := prefixPattern(g.prefix, pattern)
+
+	if err != nil {
+		return fmt.Errorf("unregister %q: %w", pattern, err)
+	}
+
+	return g.server.UnregisterEndpoint(prefixed)
+}
+
+// chainWith returns the middleware of g's ancestors, g itself and then mws,
+// outermost first.
+func (g *Group) chainWith(mws []Middleware) []Middleware {
+	var all []Middleware
+
+	for group := g; group != nil; group = group.parent {
+		all = append(append([]Middleware{}, group.middleware...), all...)
+	}
+
+	return append(all, mws...)
+}
+
+// cleanPrefix makes prefix start with a slash and end without one, so that
+// prefixes can be concatenated. "" and "/" both become "".
+func cleanPrefix(prefix string) string {
+	prefix = strings.TrimRight(prefix, "/")
+
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+
+	return prefix
+}
+
+// prefixPattern inserts prefix in front of the path of a ServeMux pattern
+// "[METHOD ][HOST]/[PATH]". A pattern without the slash has no path to put
+// the prefix in front of, and ServeMux would reject it anyway.
+func prefixPattern(prefix, pattern string) (string, error) {
+	method, rest := "", pattern
+
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 {
+		method = pattern[:i] + " "
+		rest = strings.TrimLeft(pattern[i:], " \t")
+	}
+
+	i := strings.Index(rest, "/")
+
+	if i < 0 {
+		return "", errors.New(`the path must start with "/"`)
+	}
+
+	return method + rest[:i] + prefix + rest[i:], nil
+}
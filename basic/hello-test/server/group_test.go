@@ -0,0 +1,147 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestPrefixPattern(t *testing.T) {
+	testCases := []struct {
+		name    string
+		prefix  string
+		pattern string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain path", prefix: "/v1", pattern: "/users", want: "/v1/users"},
+		{name: "method", prefix: "/v1", pattern: "POST /capitalize", want: "POST /v1/capitalize"},
+		{name: "method with extra spaces", prefix: "/v1", pattern: "GET \t /users", want: "GET /v1/users"},
+		{name: "host", prefix: "/v1", pattern: "crab.example/users", want: "crab.example/v1/users"},
+		{name: "method and host", prefix: "/v1", pattern: "GET crab.example/users/{id}", want: "GET crab.example/v1/users/{id}"},
+		{name: "subtree", prefix: "/v1", pattern: "/", want: "/v1/"},
+		{name: "exact root", prefix: "/v1", pattern: "/{$}", want: "/v1/{$}"},
+		{name: "empty prefix", prefix: "", pattern: "DELETE /users", want: "DELETE /users"},
+		{name: "no leading slash", prefix: "/v1", pattern: "capitalize", wantErr: true},
+		{name: "method without slash", prefix: "/v1", pattern: "POST capitalize", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// act
+			got, err := prefixPattern(tc.prefix, tc.pattern)
+
+			// assert
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("prefixPattern(%q, %q): got err=%v, want err=%v", tc.prefix, tc.pattern, err, tc.wantErr)
+			}
+
+			if got != tc.want {
+				t.Errorf("prefixPattern(%q, %q): got=%q, want=%q", tc.prefix, tc.pattern, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCleanPrefix(t *testing.T) {
+	for input, want := range map[string]string{"": "", "/": "", "v1": "/v1", "/v1/": "/v1", "/v1/admin": "/v1/admin"} {
+		if got := cleanPrefix(input); got != want {
+			t.Errorf("cleanPrefix(%q): got=%q, want=%q", input, got, want)
+		}
+	}
+}
+
+func TestNestedGroups(t *testing.T) {
+	// asset
+	var trace []string
+	srv := New("tcp", 8080)
+	srv.Use(tracer("global", &trace))
+
+	v1 := srv.Group("/v1")
+	v1.Use(tracer("v1", &trace))
+	admin := v1.Group("admin/")
+	admin.Use(tracer("admin", &trace))
+	admin.RegisterEndpoint("POST /capitalize", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace = append(trace, "handler")
+	}), tracer("endpoint", &trace))
+
+	v1.Group("/public").RegisterEndpoint("GET /ping", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace = append(trace, "ping")
+	}))
+
+	handler := srv.httpServer().Handler
+
+	testCases := []struct {
+		name   string
+		method string
+		target string
+		status int
+		trace  []string
+	}{
+		{
+			name:   "admin endpoint",
+			method: http.MethodPost,
+			target: "/v1/admin/capitalize",
+			status: http.StatusOK,
+			trace:  []string{"global", "v1", "admin", "endpoint", "handler", "/endpoint", "/admin", "/v1", "/global"},
+		},
+		{
+			name:   "wrong method is rejected by the mux",
+			method: http.MethodGet,
+			target: "/v1/admin/capitalize",
+			status: http.StatusMethodNotAllowed,
+			trace:  []string{"global", "/global"},
+		},
+		{
+			name:   "sibling group does not get admin middleware",
+			method: http.MethodGet,
+			target: "/v1/public/ping",
+			status: http.StatusOK,
+			trace:  []string{"global", "v1", "ping", "/v1", "/global"},
+		},
+		{
+			name:   "path without prefix",
+			method: http.MethodPost,
+			target: "/capitalize",
+			status: http.StatusNotFound,
+			trace:  []string{"global", "/global"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			trace = nil
+			rw := httptest.NewRecorder()
+
+			// act
+			handler.ServeHTTP(rw, httptest.NewRequest(tc.method, tc.target, nil))
+
+			// assert
+			if rw.Code != tc.status {
+				t.Errorf("status code: got=%v, want=%v", rw.Code, tc.status)
+			}
+
+			if !slices.Equal(trace, tc.trace) {
+				t.Errorf("middleware trace: got=%v, want=%v", trace, tc.trace)
+			}
+		})
+	}
+}
+
+func TestRoutesIncludeGroupPrefixes(t *testing.T) {
+	// asset
+	srv := New("tcp", 8080)
+	srv.RegisterEndpoint("/capitalize", http.NotFoundHandler())
+	srv.Group("/v1").RegisterEndpoint("POST /capitalize", http.NotFoundHandler())
+
+	want := []string{"GET " + LivenessPath, "GET " + ReadinessPath, "/capitalize", "POST /v1/capitalize"}
+
+	// act
+	got := srv.Routes()
+
+	// assert
+	if !slices.Equal(got, want) {
+		t.Errorf("Routes: got=%v, want=%v", got, want)
+	}
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/net/http2"
+)
+
+// h2cUpgrader serves the connections that switch to HTTP/2 with "Upgrade:
+// h2c". net/http only serves h2c with prior knowledge, so these are hijacked
+// and handed to x/net/http2, out of reach of http.Server.Shutdown; Run
+// drains them with shutdown instead.
+type h2cUpgrader struct {
+	h2 *http2.Server
+
+	// hooks is never served; it only carries the shutdown hook with which
+	// h2 sends GOAWAY on the connections it serves
+	hooks *http.Server
+
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	closing bool // no more upgrades once shutdown has started
+	wg      sync.WaitGroup
+}
+
+func newH2CUpgrader(maxConcurrentStreams int) *h2cUpgrader {
+	u := &h2cUpgrader{
+		h2:    &http2.Server{MaxConcurrentStreams: uint32(max(maxConcurrentStreams, 0))},
+		hooks: &http.Server{},
+		conns: make(map[net.Conn]struct{}),
+	}
+
+	// the only way to have h2 keep track of its connections for a graceful
+	// shutdown
+	http2.ConfigureServer(u.hooks, u.h2)
+
+	return u
+}
+
+// wrap returns a handler that switches h2c upgrade requests to HTTP/2 and
+// serves the streams of the upgraded connections with next. Other requests
+// go to next directly.
+func (u *h2cUpgrader) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settings, ok := h2cSettings(r)
+
+		if !ok || !u.track() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		defer u.wg.Done()
+
+		conn, rw, err := http.NewResponseController(w).Hijack()
+
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		u.serve(upgradedConn(conn, rw), r, settings, next)
+	})
+}
+
+// track counts an upgrade in unless shutdown has started.
+func (u *h2cUpgrader) track() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closing {
+		return false
+	}
+
+	u.wg.Add(1)
+
+	return true
+}
+
+// h2cSettings returns the decoded HTTP2-Settings of an upgrade request that
+// may switch to h2c, and false for any other request.
+func h2cSettings(r *http.Request) ([]byte, bool) {
+	if r.TLS != nil || r.ProtoMajor != 1 || r.ContentLength != 0 {
+		return nil, false
+	}
+
+	if !httpguts.HeaderValuesContainsToken(r.Header["Upgrade"], "h2c") ||
+		!httpguts.HeaderValuesContainsToken(r.Header["Connection"], "HTTP2-Settings") {
+		return nil, false
+	}
+
+	// without exactly one, RFC 9113 has the server ignore the upgrade
+	values := r.Header["Http2-Settings"]
+
+	if len(values) != 1 {
+		return nil, false
+	}
+
+	settings, err := base64.RawURLEncoding.DecodeString(values[0])
+
+	return settings, err == nil
+}
+
+// upgradedConn answers 101 Switching Protocols on conn and returns it, with
+// what was already buffered in rw read first.
+func upgradedConn(conn net.Conn, rw *bufio.ReadWriter) net.Conn {
+	// drop the deadlines of the HTTP/1.1 request; http2 sets its own
+	conn.SetDeadline(time.Time{})
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+	rw.Flush()
+
+	if rw.Reader.Buffered() == 0 {
+		return conn
+	}
+
+	return &bufferedConn{Conn: conn, r: rw.Reader}
+}
+
+// bufferedConn is a net.Conn whose reads drain r first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	if c.r != nil {
+		if n := c.r.Buffered(); n > 0 {
+			return c.r.Read(p[:min(len(p), n)])
+		}
+
+		c.r = nil
+	}
+
+	return c.Conn.Read(p)
+}
+
+// serve serves conn over HTTP/2 until the client or shutdown closes it. The
+// upgrade request r becomes stream 1.
+func (u *h2cUpgrader) serve(conn net.Conn, r *http.Request, settings []byte, handler http.Handler) {
+	// the handler answers it over HTTP/2, where these headers are forbidden
+	r = r.Clone(r.Context())
+	r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/2.0", 2, 0
+	r.Header.Del("Connection")
+	r.Header.Del("Upgrade")
+
+	u.mu.Lock()
+	u.conns[conn] = struct{}{}
+	u.mu.Unlock()
+
+	defer func() {
+		conn.Close()
+
+		u.mu.Lock()
+		delete(u.conns, conn)
+		u.mu.Unlock()
+	}()
+
+	base, _ := r.Context().Value(http.ServerContextKey).(*http.Server)
+
+	u.h2.ServeConn(conn, &http2.ServeConnOpts{
+		Context:        r.Context(),
+		BaseConfig:     base,
+		Handler:        handler,
+		UpgradeRequest: r,
+		Settings:       settings,
+	})
+}
+
+// shutdown tells the clients of the upgraded connections to stop sending
+// requests and waits for those in flight, as http.Server.Shutdown does for
+// its own connections. Once ctx expires, it closes the remaining ones.
+func (u *h2cUpgrader) shutdown(ctx context.Context) error {
+	u.mu.Lock()
+	u.closing = true
+	u.mu.Unlock()
+
+	u.hooks.Shutdown(ctx) // sends GOAWAY, there is nothing else to shut
+
+	done := make(chan struct{})
+
+	go func() {
+		u.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	u.mu.Lock()
+
+	for conn := range u.conns {
+		conn.Close()
+	}
+
+	u.mu.Unlock()
+	<-done
+
+	return fmt.Errorf("drain upgraded h2c connections: %w", ctx.Err())
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health endpoints registered by New.
+const (
+	LivenessPath  = "/livez"
+	ReadinessPath = "/readyz"
+)
+
+// DefaultCheckTimeout bounds a health check registered without a timeout.
+const DefaultCheckTimeout = 2 * time.Second
+
+// Check reports the health of one component; a nil error means healthy.
+// It should give up once ctx is done.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name    string
+	timeout time.Duration
+	check   Check
+}
+
+// CheckResult is the outcome of one check in a verbose health report.
+type CheckResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport is the JSON body of the health endpoints. Checks is only
+// filled in verbose mode.
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks,omitempty"`
+}
+
+// Values of HealthReport.Status and CheckResult.Status.
+const (
+	StatusOK       = "ok"
+	StatusFailing  = "failing"
+	StatusDraining = "draining"
+)
+
+type health struct {
+	mu        sync.RWMutex
+	liveness  []namedCheck
+	readiness []namedCheck
+
+	// draining is set once Run starts shutting down, so that load balancers
+	// stop sending new traffic while in-flight requests finish
+	draining atomic.Bool
+
+	verboseAllowed func(*http.Request) bool // nil denies every request
+}
+
+// WithVerboseHealth decides which requests may see the per-check report of
+// "?verbose". Check names and errors tell a lot about the internals, so by
+// default nobody gets it and everyone receives the overall status only.
+//
+// The remote address is no safe guide here: behind a local reverse proxy
+// every request comes from loopback. Allow e.g. the requests that arrived on
+// an internal listener added with WithListener, which the local address in
+// http.LocalAddrContextKey tells.
+func WithVerboseHealth(allow func(*http.Request) bool) Option {
+	return func(ws *Server) {
+		ws.health.verboseAllowed = allow
+	}
+}
+
+// AddLivenessCheck adds a check to /livez. A failing liveness check tells the
+// orchestrator to restart the process, so keep these to things a restart
+// would fix.
+func (ws *Server) AddLivenessCheck(name string, timeout time.Duration, check Check) {
+	ws.health.mu.Lock()
+	defer ws.health.mu.Unlock()
+
+	ws.health.liveness = append(ws.health.liveness, newNamedCheck(name, timeout, check))
+}
+
+// AddReadinessCheck adds a check to /readyz, typically for a dependency such
+// as a database. While it fails, the server is taken out of load balancing.
+func (ws *Server) AddReadinessCheck(name string, timeout time.Duration, check Check) {
+	ws.health.mu.Lock()
+	defer ws.health.mu.Unlock()
+
+	ws.health.readiness = append(ws.health.readiness, newNamedCheck(name, timeout, check))
+}
+
+func newNamedCheck(name string, timeout time.Duration, check Check) namedCheck {
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+
+	return namedCheck{name: name, timeout: timeout, check: check}
+}
+
+func (h *health) livenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.RLock()
+		checks := h.liveness
+		h.mu.RUnlock()
+
+		h.report(w, r, checks, false)
+	})
+}
+
+func (h *health) readinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.RLock()
+		checks := h.readiness
+		h.mu.RUnlock()
+
+		h.report(w, r, checks, h.draining.Load())
+	})
+}
+
+// report runs checks concurrently and writes the JSON report, with status 503
+// if any of them failed or the server is draining.
+func (h *health) report(w http.ResponseWriter, r *http.Request, checks []namedCheck, draining bool) {
+	results := runChecks(r.Context(), checks)
+	report := HealthReport{Status: StatusOK}
+
+	for _, result := range results {
+		if result.Status != StatusOK {
+			report.Status = StatusFailing
+		}
+	}
+
+	if draining {
+		report.Status = StatusDraining
+	}
+
+	if r.URL.Query().Has("verbose") && h.verboseAllowed != nil && h.verboseAllowed(r) {
+		report.Checks = results
+	}
+
+	status := http.StatusOK
+
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// runChecks runs every check with its own timeout. A check that ignores its
+// context is reported as timed out without waiting for it to return.
+func runChecks(ctx context.Context, checks []namedCheck) []CheckResult {
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+
+	for i, nc := range checks {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, nc.timeout)
+			defer cancel()
+
+			start := time.Now()
+			done := make(chan error, 1)
+
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						done <- fmt.Errorf("check panicked: %v", p)
+					}
+				}()
+
+				done <- nc.check(checkCtx)
+			}()
+
+			var err error
+
+			select {
+			case err = <-done:
+			case <-checkCtx.Done():
+				err = checkCtx.Err()
+			}
+
+			results[i] = CheckResult{
+				Name:      nc.name,
+				Status:    StatusOK,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+
+			if err != nil {
+				results[i].Status = StatusFailing
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// probe sends a GET to target from remoteAddr, with token in the
+// X-Health-Token header, and decodes the report.
+func probe(t *testing.T, handler http.Handler, target, remoteAddr, token string) (int, HealthReport) {
+	t.Helper()
+
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	request.RemoteAddr = remoteAddr
+	request.Header.Set("X-Health-Token", token)
+	rw := httptest.NewRecorder()
+
+	handler.ServeHTTP(rw, request)
+
+	var report HealthReport
+
+	if err := json.NewDecoder(rw.Body).Decode(&report); err != nil {
+		t.Fatalf("unexpected report decode error: %v", err)
+	}
+
+	return rw.Code, report
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	// asset
+	dbErr := errors.New("connection refused")
+	checked := func(opts ...Option) http.Handler {
+		srv := New("tcp", 8080, opts...)
+		srv.AddLivenessCheck("goroutines", 0, func(ctx context.Context) error { return nil })
+		srv.AddReadinessCheck("cache", time.Second, func(ctx context.Context) error { return nil })
+		srv.AddReadinessCheck("db", time.Second, func(ctx context.Context) error { return dbErr })
+		srv.AddReadinessCheck("queue", 20*time.Millisecond, func(ctx context.Context) error {
+			time.Sleep(time.Second) // ignores its context
+			return nil
+		})
+
+		return srv.httpServer().Handler
+	}
+
+	handler := checked()
+	verboseHandler := checked(WithVerboseHealth(func(r *http.Request) bool {
+		return r.Header.Get("X-Health-Token") == "wolfpack"
+	}))
+
+	testCases := []struct {
+		name       string
+		handler    http.Handler
+		target     string
+		remoteAddr string
+		header     string
+		status     int
+		report     HealthReport
+	}{
+		{
+			name:       "liveness",
+			handler:    handler,
+			target:     LivenessPath,
+			remoteAddr: "192.0.2.1:1234",
+			status:     http.StatusOK,
+			report:     HealthReport{Status: StatusOK},
+		},
+		{
+			name:       "readiness hides details",
+			handler:    handler,
+			target:     ReadinessPath + "?verbose",
+			remoteAddr: "192.0.2.1:1234",
+			status:     http.StatusServiceUnavailable,
+			report:     HealthReport{Status: StatusFailing},
+		},
+		{
+			name:       "readiness hides details from loopback too",
+			handler:    handler,
+			target:     ReadinessPath + "?verbose",
+			remoteAddr: "127.0.0.1:1234",
+			status:     http.StatusServiceUnavailable,
+			report:     HealthReport{Status: StatusFailing},
+		},
+		{
+			name:       "verbose readiness when allowed",
+			handler:    verboseHandler,
+			target:     ReadinessPath + "?verbose",
+			remoteAddr: "192.0.2.1:1234",
+			header:     "wolfpack",
+			status:     http.StatusServiceUnavailable,
+			report: HealthReport{Status: StatusFailing, Checks: []CheckResult{
+				{Name: "cache", Status: StatusOK},
+				{Name: "db", Status: StatusFailing, Error: dbErr.Error()},
+				{Name: "queue", Status: StatusFailing, Error: context.DeadlineExceeded.Error()},
+			}},
+		},
+		{
+			name:       "verbose readiness when not allowed",
+			handler:    verboseHandler,
+			target:     ReadinessPath + "?verbose",
+			remoteAddr: "192.0.2.1:1234",
+			header:     "kaman",
+			status:     http.StatusServiceUnavailable,
+			report:     HealthReport{Status: StatusFailing},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// act
+			status, report := probe(t, tc.handler, tc.target, tc.remoteAddr, tc.header)
+
+			// assert
+			if status != tc.status {
+				t.Errorf("status code: got=%v, want=%v", status, tc.status)
+			}
+
+			if report.Status != tc.report.Status || len(report.Checks) != len(tc.report.Checks) {
+				t.Fatalf("report: got=%+v, want=%+v", report, tc.report)
+			}
+
+			for i, got := range report.Checks {
+				want := tc.report.Checks[i]
+				got.LatencyMS = 0
+
+				if got != want {
+					t.Errorf("check %v: got=%+v, want=%+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthCheckLatency(t *testing.T) {
+	// asset
+	srv := New("tcp", 8080, WithVerboseHealth(func(*http.Request) bool { return true }))
+	srv.AddReadinessCheck("slow", time.Second, func(ctx context.Context) error {
+		time.Sleep(30 * time.Millisecond)
+		return nil
+	})
+
+	// act
+	_, report := probe(t, srv.httpServer().Handler, ReadinessPath+"?verbose", "192.0.2.1:1234", "")
+
+	// assert
+	if len(report.Checks) != 1 || report.Checks[0].LatencyMS < 30 {
+		t.Errorf("latency of a 30ms check: got=%+v", report.Checks)
+	}
+}
+
+// getReadiness probes /readyz at addr over a new connection, the way a load
+// balancer would.
+func getReadiness(addr string) (int, HealthReport, error) {
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	response, err := client.Get("http://" + addr + ReadinessPath)
+
+	if err != nil {
+		return 0, HealthReport{}, err
+	}
+
+	defer response.Body.Close()
+
+	var report HealthReport
+	err = json.NewDecoder(response.Body).Decode(&report)
+
+	return response.StatusCode, report, err
+}
+
+func TestReadinessFailsWhileDraining(t *testing.T) {
+	// asset
+	const delay = 300 * time.Millisecond
+
+	srv := New("tcp", 0, WithAddr("127.0.0.1:0"), WithShutdownDelay(delay))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- srv.Run(ctx)
+	}()
+
+	addr := waitReady(t, srv, done)
+
+	if status, _, err := getReadiness(addr); err != nil || status != http.StatusOK {
+		t.Fatalf("readiness before shutdown: got=(%v, %v), want=(%v, nil)", status, err, http.StatusOK)
+	}
+
+	// act
+	stopped := time.Now()
+	cancel()
+
+	// assert: the listener still accepts, and /readyz says it is draining
+	var status int
+	var report HealthReport
+	var err error
+
+	for deadline := time.Now().Add(delay); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if status, report, err = getReadiness(addr); err != nil || report.Status == StatusDraining {
+			break
+		}
+	}
+
+	if err != nil || status != http.StatusServiceUnavailable || report.Status != StatusDraining {
+		t.Errorf("readiness while draining: got=(%v, %v, %v), want=(%v, %v, nil)", status, report.Status, err, http.StatusServiceUnavailable, StatusDraining)
+	}
+
+	waitRefusing(t, addr)
+
+	if err := <-done; err != nil {
+		t.Errorf("unexpected Run error: %v", err)
+	}
+
+	if elapsed := time.Since(stopped); elapsed < delay {
+		t.Errorf("Run returned after %v, before the shutdown delay of %v", elapsed, delay)
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import "net/http"
+
+// WithH2C lets clients speak HTTP/2 without TLS ("h2c") on plain listeners,
+// e.g. inside a service mesh that encrypts between hosts itself. HTTP/1.1
+// keeps working on the same port, and TLS listeners negotiate HTTP/2 through
+// ALPN either way.
+//
+// Clients may open the connection with the HTTP/2 preface (prior knowledge)
+// or send an HTTP/1.1 request with "Upgrade: h2c", which RFC 9113 has
+// deprecated but older clients still use. An upgrade request with a body is
+// answered over HTTP/1.1 instead, as RFC 9110 allows, so that the body need
+// not be buffered while switching protocols.
+func WithH2C() Option {
+	return func(ws *Server) {
+		ws.h2c = true
+	}
+}
+
+// WithMaxConcurrentStreams limits how many requests a client may have in
+// flight on one HTTP/2 connection. Without it net/http allows at least 100.
+func WithMaxConcurrentStreams(n int) Option {
+	return func(ws *Server) {
+		ws.maxConcurrentStreams = n
+	}
+}
+
+// protocols returns the protocols the listeners serve, nil meaning the
+// net/http default of HTTP/1.1, plus HTTP/2 over TLS.
+func (ws *Server) protocols() *http.Protocols {
+	if !ws.h2c {
+		return nil
+	}
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+
+	return protocols
+}
+
+// http2Config returns the HTTP/2 settings of the listeners.
+func (ws *Server) http2Config() *http.HTTP2Config {
+	if ws.maxConcurrentStreams <= 0 {
+		return nil
+	}
+
+	return &http.HTTP2Config{MaxConcurrentStreams: ws.maxConcurrentStreams}
+}
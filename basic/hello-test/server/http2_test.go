@@ -0,0 +1,383 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// h2cClient speaks HTTP/2 with prior knowledge over plain TCP and counts the
+// connections it opens.
+func h2cClient(dials *atomic.Int32) *http.Client {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+
+	return &http.Client{Transport: &http.Transport{
+		Protocols: protocols,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials.Add(1)
+
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+func h2cServer(t *testing.T, opts ...Option) (*Server, string) {
+	t.Helper()
+
+	opts = append([]Option{WithAddr("127.0.0.1:0"), WithLogger(log.New(io.Discard, "", 0))}, opts...)
+	srv := New("tcp", 0, opts...)
+	srv.RegisterEndpoint("POST /capitalize", http.HandlerFunc(CapitalizeHandler(log.New(io.Discard, "", 0))))
+	srv.RegisterEndpoint("/proto", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+
+	return srv, "http://" + waitReady(t, srv, runServer(t, srv))
+}
+
+func warmUp(t *testing.T, client *http.Client, url string) {
+	t.Helper()
+
+	response, err := client.Get(url + "/proto")
+
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+
+	response.Body.Close()
+}
+
+func TestH2CMultiplexesCapitalizeRequests(t *testing.T) {
+	// asset
+	_, url := h2cServer(t, WithH2C())
+	var dials atomic.Int32
+	client := h2cClient(&dials)
+	const requests = 20
+
+	// requests that start together before a connection exists each dial
+	// their own
+	warmUp(t, client, url)
+
+	// act
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+
+	for i := range requests {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			input := fmt.Sprintf("ken jeong %v", i)
+			response, err := client.Post(url+"/capitalize", "text/plain", strings.NewReader(input))
+
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			defer response.Body.Close()
+			body, _ := io.ReadAll(response.Body)
+
+			if response.ProtoMajor != 2 || string(body) != strings.ToUpper(input) {
+				errs <- fmt.Errorf("got=(%v, %q), want=(HTTP/2.0, %q)", response.Proto, body, strings.ToUpper(input))
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	// assert
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := dials.Load(); got != 1 {
+		t.Errorf("connections: got=%v, want=%v", got, 1)
+	}
+}
+
+func TestMaxConcurrentStreams(t *testing.T) {
+	// asset
+	const requests = 5
+	var mu sync.Mutex
+	perConn := map[string]int{} // requests in flight per client connection
+	peak := 0
+	arrived := make(chan struct{}, requests)
+	release := make(chan struct{})
+	srv, url := h2cServer(t, WithH2C(), WithMaxConcurrentStreams(2))
+
+	srv.RegisterEndpoint("/wait", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		perConn[r.RemoteAddr]++
+		peak = max(peak, perConn[r.RemoteAddr])
+		mu.Unlock()
+
+		arrived <- struct{}{}
+		<-release
+	}))
+
+	var dials atomic.Int32
+	client := h2cClient(&dials)
+	warmUp(t, client, url)
+
+	// act
+	var wg sync.WaitGroup
+
+	for range requests {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if response, err := client.Get(url + "/wait"); err == nil {
+				response.Body.Close()
+			}
+		}()
+	}
+
+	for range requests {
+		<-arrived
+	}
+
+	close(release)
+	wg.Wait()
+
+	// assert
+	if peak != 2 {
+		t.Errorf("requests in flight on one connection: got=%v, want=%v", peak, 2)
+	}
+
+	// the client opens a new connection once the others are full
+	if len(perConn) < 3 {
+		t.Errorf("connections for %v requests: got=%v, want at least %v", requests, len(perConn), 3)
+	}
+}
+
+func TestHTTP1WithAndWithoutH2C(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opts     []Option
+		h2cWorks bool
+	}{
+		{name: "default", opts: nil, h2cWorks: false},
+		{name: "h2c", opts: []Option{WithH2C()}, h2cWorks: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			_, url := h2cServer(t, tc.opts...)
+			var dials atomic.Int32
+
+			// act
+			h2cResponse, h2cErr := h2cClient(&dials).Get(url + "/proto")
+			response, err := http.Get(url + "/proto")
+
+			// assert
+			if h2cErr == nil {
+				h2cResponse.Body.Close()
+			}
+
+			if (h2cErr == nil) != tc.h2cWorks {
+				t.Errorf("prior knowledge h2c: got err=%v, want working=%v", h2cErr, tc.h2cWorks)
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected HTTP/1.1 error: %v", err)
+			}
+
+			defer response.Body.Close()
+			body, _ := io.ReadAll(response.Body)
+
+			if response.StatusCode != http.StatusOK || string(body) != "HTTP/1.1" {
+				t.Errorf("HTTP/1.1 request: got=(%v, %q), want=(%v, %q)", response.StatusCode, body, http.StatusOK, "HTTP/1.1")
+			}
+		})
+	}
+}
+
+// upgrade sends an HTTP/1.1 request on conn asking to switch to h2c, and
+// returns the protocol and body of the response, read from stream 1 of
+// HTTP/2 if the server switched.
+func upgrade(conn net.Conn, method, path, body string) (proto, got string, err error) {
+	fmt.Fprintf(conn, "%v %v HTTP/1.1\r\nHost: crab\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQCAAAAAAIAAAAA\r\nContent-Type: text/plain\r\nContent-Length: %v\r\n\r\n%v", method, path, len(body), body)
+
+	br := bufio.NewReader(conn)
+	response, err := http.ReadResponse(br, nil)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		defer response.Body.Close()
+		b, err := io.ReadAll(response.Body)
+
+		return response.Proto, string(b), err
+	}
+
+	io.WriteString(conn, http2.ClientPreface)
+	framer := http2.NewFramer(conn, br)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+
+	if err := framer.WriteSettings(); err != nil {
+		return "", "", err
+	}
+
+	var data []byte
+
+	for {
+		frame, err := framer.ReadFrame()
+
+		if err != nil {
+			return "", "", err
+		}
+
+		switch f := frame.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				framer.WriteSettingsAck()
+			}
+		case *http2.MetaHeadersFrame:
+			if status := f.PseudoValue("status"); f.StreamID == 1 && status != "200" {
+				return "", "", fmt.Errorf("status of stream 1: %v", status)
+			}
+		case *http2.DataFrame:
+			if f.StreamID == 1 {
+				data = append(data, f.Data()...)
+
+				if f.StreamEnded() {
+					return "HTTP/2.0", string(data), nil
+				}
+			}
+		case *http2.GoAwayFrame:
+			// a graceful shutdown lets stream 1 finish
+			if f.ErrCode != http2.ErrCodeNo {
+				return "", "", fmt.Errorf("GOAWAY: %v", f.ErrCode)
+			}
+		}
+	}
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	testCases := []struct {
+		name   string
+		opts   []Option
+		method string
+		path   string
+		body   string
+		proto  string
+		want   string
+	}{
+		{name: "without h2c", method: http.MethodGet, path: "/proto", proto: "HTTP/1.1", want: "HTTP/1.1"},
+		{name: "h2c", opts: []Option{WithH2C()}, method: http.MethodGet, path: "/proto", proto: "HTTP/2.0", want: "HTTP/2.0"},
+		{
+			// switching would mean buffering the body
+			name:   "h2c with a body",
+			opts:   []Option{WithH2C()},
+			method: http.MethodPost,
+			path:   "/capitalize",
+			body:   "ken jeong",
+			proto:  "HTTP/1.1",
+			want:   "KEN JEONG",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			_, url := h2cServer(t, tc.opts...)
+			conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+
+			if err != nil {
+				t.Fatalf("unexpected dial error: %v", err)
+			}
+
+			defer conn.Close()
+
+			// act
+			proto, got, err := upgrade(conn, tc.method, tc.path, tc.body)
+
+			// assert
+			if err != nil || proto != tc.proto || got != tc.want {
+				t.Errorf("got=(%v, %q, %v), want=(%v, %q, nil)", proto, got, err, tc.proto, tc.want)
+			}
+		})
+	}
+}
+
+func TestH2CUpgradedConnectionDrains(t *testing.T) {
+	// asset
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := New("tcp", 0, WithAddr("127.0.0.1:0"), WithLogger(log.New(io.Discard, "", 0)), WithH2C())
+	srv.RegisterEndpoint("/slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "finished")
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- srv.Run(ctx)
+	}()
+
+	conn, err := net.Dial("tcp", waitReady(t, srv, done))
+
+	if err != nil {
+		t.Fatalf("unexpected dial error: %v", err)
+	}
+
+	defer conn.Close()
+
+	type result struct {
+		proto, body string
+		err         error
+	}
+
+	results := make(chan result, 1)
+
+	go func() {
+		proto, body, err := upgrade(conn, http.MethodGet, "/slow", "")
+		results <- result{proto, body, err}
+	}()
+
+	<-started
+
+	// act
+	cancel()
+
+	// assert: Run waits for the request on the upgraded connection
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned %v with a request in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	if got := <-results; got.err != nil || got.proto != "HTTP/2.0" || got.body != "finished" {
+		t.Errorf("got=(%v, %q, %v), want=(HTTP/2.0, %q, nil)", got.proto, got.body, got.err, "finished")
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("unexpected Run error: %v", err)
+	}
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"cmp"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation (SD_LISTEN_FDS_START). It is a variable so tests can hand over
+// descriptors they opened themselves.
+var listenFDsStart = 3
+
+// WithSocketMode sets the permission bits of the unix socket created by Run,
+// e.g. 0o660 to let a reverse proxy in the same group connect.
+func WithSocketMode(mode os.FileMode) Option {
+	return func(ws *Server) {
+		ws.socketMode = mode
+	}
+}
+
+// WithSocketOwner changes the owner of the unix socket created by Run.
+// An id of -1 leaves that id unchanged.
+func WithSocketOwner(uid, gid int) Option {
+	return func(ws *Server) {
+		ws.socketUID = uid
+		ws.socketGID = gid
+	}
+}
+
+// WithSocketActivation makes Run serve on the listener inherited from systemd
+// (LISTEN_PID/LISTEN_FDS) instead of opening its own.
+func WithSocketActivation() Option {
+	return func(ws *Server) {
+		ws.activation = true
+	}
+}
+
+// Listener is an address Run serves on in addition to the one given to New,
+// with the same routes. It lets one Server answer on a public HTTPS port, an
+// internal plain HTTP port and a unix socket at once.
+type Listener struct {
+	// Network is "tcp", "tcp4", "tcp6" or "unix"; empty means "tcp".
+	Network string
+
+	// Address is a host:port, e.g. ":8080" or "127.0.0.1:0", or the path of
+	// a unix socket, which is created like the one of WithAddr.
+	Address string
+
+	// TLS serves HTTPS with the PEM files CertFile and KeyFile, which work
+	// as in WithTLS. The listener does not inherit WithTLS.
+	TLS      bool
+	CertFile string
+	KeyFile  string
+
+	// Middleware replaces the global middleware of Use on this listener,
+	// e.g. to leave out authentication on an internal port. Nil keeps the
+	// global middleware; an empty slice runs none.
+	Middleware []Middleware
+}
+
+// WithListener makes Run serve on l as well. Run opens all listeners before
+// it serves any of them.
+func WithListener(l Listener) Option {
+	return func(ws *Server) {
+		ws.extraListeners = append(ws.extraListeners, l)
+	}
+}
+
+// boundListener is an open listener and the http.Server that serves it.
+type boundListener struct {
+	listener net.Listener
+	srv      *http.Server
+
+	// kept apart from srv.TLSConfig, which net/http may fill in itself
+	tlsConfig *tls.Config
+}
+
+// serve serves b until it is shut down or fails.
+func (b boundListener) serve() error {
+	var err error
+
+	if b.tlsConfig != nil {
+		// the certificate comes from TLSConfig.GetCertificate
+		err = b.srv.ServeTLS(b.listener, "", "")
+	} else {
+		err = b.srv.Serve(b.listener)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return fmt.Errorf("serve on %v: %w", b.listener.Addr(), err)
+}
+
+// openListeners opens the listener of New and those of WithListener. If one
+// of them cannot be opened, the others are closed again.
+func (ws *Server) openListeners() (bound []boundListener, err error) {
+	defer func() {
+		if err != nil {
+			for _, b := range bound {
+				b.listener.Close()
+			}
+		}
+	}()
+
+	primary, err := ws.bind(ws.listen, ws.address(), ws.tls, ws.middleware)
+
+	if err != nil {
+		return nil, err // unexpected error
+	}
+
+	bound = append(bound, primary)
+
+	for _, l := range ws.extraListeners {
+		network := cmp.Or(l.Network, "tcp")
+		var files *tlsFiles
+
+		if l.TLS {
+			files = &tlsFiles{certFile: l.CertFile, keyFile: l.KeyFile}
+		}
+
+		mws := ws.middleware
+
+		if l.Middleware != nil {
+			mws = l.Middleware
+		}
+
+		open := func() (net.Listener, error) {
+			return ws.listenOn(network, l.Address)
+		}
+
+		b, err := ws.bind(open, l.Address, files, mws)
+
+		if err != nil {
+			return bound, fmt.Errorf("listen on %v %v: %w", network, l.Address, err)
+		}
+
+		bound = append(bound, b)
+	}
+
+	return bound, nil
+}
+
+// bind opens a listener and builds the http.Server for it.
+func (ws *Server) bind(open func() (net.Listener, error), addr string, files *tlsFiles, mws []Middleware) (boundListener, error) {
+	b := boundListener{srv: ws.httpServerWith(addr, mws)}
+
+	if files != nil {
+		tlsConfig, err := files.tlsConfig(ws.logger)
+
+		if err != nil {
+			return boundListener{}, err
+		}
+
+		b.srv.TLSConfig = tlsConfig
+		b.tlsConfig = tlsConfig
+	}
+
+	listener, err := open()
+
+	if err != nil {
+		return boundListener{}, err
+	}
+
+	b.listener = listener
+
+	return b, nil
+}
+
+// listen opens the listener of New.
+func (ws *Server) listen() (net.Listener, error) {
+	if ws.activation {
+		listeners, err := ActivationListeners()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(listeners) == 0 {
+			return nil, errors.New("socket activation: no listeners were passed to this process")
+		}
+
+		for _, extra := range listeners[1:] {
+			ws.logger.Printf("socket activation: ignoring extra listener on %v", extra.Addr())
+			extra.Close()
+		}
+
+		return listeners[0], nil
+	}
+
+	if ws.protocol == "unix" {
+		return ws.listenOn("unix", ws.addr)
+	}
+
+	return ws.listenOn(ws.protocol, ws.address())
+}
+
+// listenOn opens a listener on addr; unix sockets are set up by listenUnix.
+func (ws *Server) listenOn(network, addr string) (net.Listener, error) {
+	if network == "unix" {
+		return ws.listenUnix(addr)
+	}
+
+	return net.Listen(network, addr)
+}
+
+// listenUnix creates the unix socket at path, replacing a socket file left
+// behind by a crashed process. The socket file is removed again when the
+// listener is closed.
+func (ws *Server) listenUnix(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("unix socket: no path configured, use WithAddr")
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ws.socketMode != 0 {
+		if err := os.Chmod(path, ws.socketMode); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("unix socket: %w", err)
+		}
+	}
+
+	if ws.socketUID != -1 || ws.socketGID != -1 {
+		if err := os.Chown(path, ws.socketUID, ws.socketGID); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("unix socket: %w", err)
+		}
+	}
+
+	return listener, nil
+}
+
+// removeStaleSocket deletes the socket file at path unless another process is
+// still accepting connections on it. Anything that is not a socket is left
+// alone.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("unix socket: %v exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("unix socket: %v is in use by another process", path)
+	}
+
+	return os.Remove(path)
+}
+
+// ActivationListeners returns the listeners passed to this process through
+// systemd socket activation, in the order of LISTEN_FDS. It returns no
+// listeners when the variables are unset or meant for another process. The
+// variables are unset afterwards so that child processes do not inherit them.
+func ActivationListeners() ([]net.Listener, error) {
+	pidValue, fdsValue := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+
+	if pidValue == "" || fdsValue == "" {
+		return nil, nil
+	}
+
+	pid, err := strconv.Atoi(pidValue)
+
+	if err != nil {
+		return nil, fmt.Errorf("socket activation: invalid LISTEN_PID %q", pidValue)
+	}
+
+	if pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(fdsValue)
+
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("socket activation: invalid LISTEN_FDS %q", fdsValue)
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, count)
+
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("LISTEN_FD_%v", listenFDsStart+i)
+
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		// net.FileListener works on a duplicate, so the inherited descriptor
+		// is closed right away
+		file := os.NewFile(uintptr(listenFDsStart+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+
+			return nil, fmt.Errorf("socket activation: descriptor %v (%v): %w", listenFDsStart+i, name, err)
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
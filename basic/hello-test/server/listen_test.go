@@ -0,0 +1,380 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// unixClient talks HTTP to the unix socket at path.
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+// runServer runs srv until the test ends.
+func runServer(t *testing.T, srv *Server) chan error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- srv.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return done
+}
+
+func helloServer(opts ...Option) *Server {
+	opts = append([]Option{WithLogger(log.New(io.Discard, "", 0))}, opts...)
+	srv := New("unix", 0, opts...)
+	srv.RegisterEndpoint("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+
+	return srv
+}
+
+func TestUnixSocketLifecycle(t *testing.T) {
+	// asset
+	path := filepath.Join(t.TempDir(), "crab.sock")
+
+	stale, err := net.Listen("unix", path)
+
+	if err != nil {
+		t.Fatalf("unexpected listen error: %v", err)
+	}
+
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close() // leaves the socket file behind, as a crash would
+
+	srv := helloServer(WithAddr(path), WithSocketMode(0o660), WithSocketOwner(os.Getuid(), os.Getgid()))
+
+	// act
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- srv.Run(ctx)
+	}()
+
+	waitReady(t, srv, done)
+	response, err := unixClient(path).Get("http://crab/")
+
+	// assert
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+
+	if string(body) != "hello" {
+		t.Errorf("response over the unix socket: got=%v, want=%v", string(body), "hello")
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		t.Fatalf("unexpected stat error: %v", err)
+	}
+
+	if info.Mode().Perm() != 0o660 {
+		t.Errorf("socket mode: got=%v, want=%v", info.Mode().Perm(), fs.FileMode(0o660))
+	}
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected Run error: %v", err)
+	}
+
+	if _, err := os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("socket file was not removed on shutdown: %v", err)
+	}
+}
+
+func TestUnixSocketInUse(t *testing.T) {
+	// asset
+	path := filepath.Join(t.TempDir(), "crab.sock")
+	live, err := net.Listen("unix", path)
+
+	if err != nil {
+		t.Fatalf("unexpected listen error: %v", err)
+	}
+
+	defer live.Close()
+
+	// act
+	err = helloServer(WithAddr(path)).Run(context.Background())
+
+	// assert
+	if err == nil {
+		t.Errorf("Run took over a socket that is still in use")
+	}
+}
+
+func TestUnixSocketKeepsRegularFiles(t *testing.T) {
+	// asset
+	path := filepath.Join(t.TempDir(), "crab.sock")
+	os.WriteFile(path, []byte("precious"), 0o600)
+
+	// act
+	err := helloServer(WithAddr(path)).Run(context.Background())
+
+	// assert
+	if err == nil {
+		t.Errorf("Run replaced a regular file")
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "precious" {
+		t.Errorf("regular file was modified: got=%q", data)
+	}
+}
+
+// passListener hands a fresh TCP listener over the way systemd would and
+// returns its address.
+func passListener(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("unexpected listen error: %v", err)
+	}
+
+	defer listener.Close()
+
+	file, err := listener.(*net.TCPListener).File()
+
+	if err != nil {
+		t.Fatalf("unexpected file error: %v", err)
+	}
+
+	defer file.Close()
+
+	// a raw duplicate that is owned by nobody but ActivationListeners
+	fd, err := syscall.Dup(int(file.Fd()))
+
+	if err != nil {
+		t.Fatalf("unexpected dup error: %v", err)
+	}
+
+	previous := listenFDsStart
+	listenFDsStart = fd
+	t.Cleanup(func() { listenFDsStart = previous })
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "http")
+
+	return listener.Addr().String()
+}
+
+func TestSocketActivation(t *testing.T) {
+	// asset
+	addr := passListener(t)
+	srv := helloServer(WithSocketActivation())
+
+	// act
+	waitReady(t, srv, runServer(t, srv))
+	response, err := http.Get("http://" + addr + "/")
+
+	// assert
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+
+	if string(body) != "hello" {
+		t.Errorf("response over the inherited listener: got=%v, want=%v", string(body), "hello")
+	}
+
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Errorf("LISTEN_FDS is still set and would leak into child processes")
+	}
+}
+
+func TestActivationListenersForAnotherProcess(t *testing.T) {
+	// asset
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	// act
+	listeners, err := ActivationListeners()
+
+	// assert
+	if err != nil || len(listeners) != 0 {
+		t.Errorf("listeners meant for another process: got=(%v, %v), want=([], nil)", listeners, err)
+	}
+}
+
+func TestSocketActivationWithoutListeners(t *testing.T) {
+	// asset
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+
+	// act
+	done := make(chan error, 1)
+
+	go func() {
+		done <- helloServer(WithSocketActivation()).Run(context.Background())
+	}()
+
+	// assert
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Run without inherited listeners: got=nil, want an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run kept running without a listener")
+	}
+}
+
+func TestMultipleListeners(t *testing.T) {
+	// asset
+	path := filepath.Join(t.TempDir(), "sidecar.sock")
+	srv := New(
+		"tcp", 0,
+		WithAddr("127.0.0.1:0"),
+		WithLogger(log.New(io.Discard, "", 0)),
+		WithListener(Listener{Address: "127.0.0.1:0", TLS: true, Middleware: []Middleware{}}),
+		WithListener(Listener{Network: "unix", Address: path}),
+	)
+	srv.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Global", "yes")
+			next.ServeHTTP(w, r)
+		})
+	})
+	srv.RegisterEndpoint("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello over %v", r.TLS != nil)
+	}))
+
+	// act
+	waitReady(t, srv, runServer(t, srv))
+	addrs := srv.Addrs()
+
+	// assert
+	if len(addrs) != 3 {
+		t.Fatalf("Addrs: got=%v, want 3 addresses", addrs)
+	}
+
+	insecure := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	testCases := []struct {
+		name   string
+		client *http.Client
+		url    string
+		want   string
+		global string
+	}{
+		{name: "plain", client: http.DefaultClient, url: "http://" + addrs[0].String(), want: "hello over false", global: "yes"},
+		{name: "tls without middleware", client: insecure, url: "https://" + addrs[1].String(), want: "hello over true", global: ""},
+		{name: "unix socket", client: unixClient(path), url: "http://crab", want: "hello over false", global: "yes"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := tc.client.Get(tc.url + "/")
+
+			if err != nil {
+				t.Fatalf("unexpected client error: %v", err)
+			}
+
+			body, _ := io.ReadAll(response.Body)
+			response.Body.Close()
+
+			if string(body) != tc.want {
+				t.Errorf("body: got=%v, want=%v", string(body), tc.want)
+			}
+
+			if got := response.Header.Get("X-Global"); got != tc.global {
+				t.Errorf("global middleware: got=%q, want=%q", got, tc.global)
+			}
+		})
+	}
+}
+
+func TestFailingListenerStopsAll(t *testing.T) {
+	// asset
+	srv := helloServer(WithAddr(filepath.Join(t.TempDir(), "crab.sock")), WithListener(Listener{Address: "127.0.0.1:0"}))
+	done := make(chan error, 1)
+
+	go func() {
+		done <- srv.Run(context.Background())
+	}()
+
+	waitReady(t, srv, done)
+
+	// act
+	srv.mu.Lock()
+	srv.bound[1].listener.Close()
+	srv.mu.Unlock()
+
+	// assert
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "serve on 127.0.0.1") {
+			t.Errorf("Run: got=%v, want the error of the failed listener", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run kept serving after a listener failed")
+	}
+
+	if _, err := net.Dial("unix", srv.Addr().String()); err == nil {
+		t.Errorf("the unix socket still accepts connections")
+	}
+}
+
+func TestListenerThatCannotOpenClosesTheOthers(t *testing.T) {
+	// asset
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("unexpected listen error: %v", err)
+	}
+
+	defer busy.Close()
+
+	path := filepath.Join(t.TempDir(), "crab.sock")
+	srv := helloServer(WithAddr(path), WithListener(Listener{Address: busy.Addr().String()}))
+
+	// act
+	err = srv.Run(context.Background())
+
+	// assert
+	if err == nil || !strings.Contains(err.Error(), busy.Addr().String()) {
+		t.Errorf("Run: got=%v, want an error naming %v", err, busy.Addr())
+	}
+
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("the unix socket was left open: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import "net/http"
+
+// Middleware wraps a handler with logic that runs before and/or after it.
+//
+// Ordering: global middleware added with Server.Use runs first, before the
+// request is routed, in the order it was added. Endpoint middleware given to
+// RegisterEndpoint runs after routing, in argument order, and only for that
+// endpoint. Middleware of a Group sits in between, outer groups first. So for
+//
+//	srv.Use(a, b)
+//	api := srv.Group("/api")
+//	api.Use(g)
+//	api.RegisterEndpoint("/x", h, c, d)
+//
+// a request to /api/x passes through a, b, g, c, d and then h, and the
+// responses unwind in the opposite order.
+type Middleware func(http.Handler) http.Handler
+
+// chain wraps handler so that mws[0] is the outermost middleware.
+func chain(handler http.Handler, mws []Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i](handler)
+	}
+
+	return handler
+}
+
+// Use adds global middleware that wraps every request, including the ones no
+// endpoint matches. The chain is built once when Run starts, so middleware
+// added afterwards has no effect.
+func (ws *Server) Use(mws ...Middleware) {
+	ws.middleware = append(ws.middleware, mws...)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// tracer returns a middleware that appends name to trace before and
+// "/"+name after the wrapped handler.
+func tracer(name string, trace *[]string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*trace = append(*trace, name)
+			next.ServeHTTP(w, r)
+			*trace = append(*trace, "/"+name)
+		})
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	// asset
+	var trace []string
+	srv := New("tcp", 8080)
+	srv.Use(tracer("a", &trace), tracer("b", &trace))
+	srv.RegisterEndpoint("/x", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace = append(trace, "handler")
+	}), tracer("c", &trace), tracer("d", &trace))
+	srv.Use(tracer("e", &trace))
+
+	handler := srv.httpServer().Handler
+	want := []string{"a", "b", "e", "c", "d", "handler", "/d", "/c", "/e", "/b", "/a"}
+
+	// act
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil))
+
+	// assert
+	if !slices.Equal(trace, want) {
+		t.Errorf("middleware order: got=%v, want=%v", trace, want)
+	}
+}
+
+func TestGlobalMiddlewareWrapsUnmatchedRequests(t *testing.T) {
+	// asset
+	var trace []string
+	srv := New("tcp", 8080)
+	srv.Use(tracer("global", &trace))
+	srv.RegisterEndpoint("/x", http.NotFoundHandler(), tracer("endpoint", &trace))
+
+	rw := httptest.NewRecorder()
+
+	// act
+	srv.httpServer().Handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+
+	// assert
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("status code: got=%v, want=%v", rw.Code, http.StatusNotFound)
+	}
+
+	if want := []string{"global", "/global"}; !slices.Equal(trace, want) {
+		t.Errorf("middleware for an unmatched path: got=%v, want=%v", trace, want)
+	}
+}
+
+func TestMiddlewareChainIsBuiltOnce(t *testing.T) {
+	// asset
+	built := 0
+	srv := New("tcp", 8080)
+	srv.Use(func(next http.Handler) http.Handler {
+		built++
+		return next
+	})
+	srv.RegisterEndpoint("/x", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	handler := srv.httpServer().Handler
+
+	// act
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil))
+	}
+
+	// assert
+	if built != 1 {
+		t.Errorf("global middleware constructed %v times, want once", built)
+	}
+}
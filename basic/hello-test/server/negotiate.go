@@ -0,0 +1,302 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Media types understood by the endpoints.
+const (
+	mediaText      = "text/plain"
+	mediaJSON      = "application/json"
+	mediaForm      = "application/x-www-form-urlencoded"
+	mediaMultipart = "multipart/form-data"
+)
+
+// requestError is a problem with the request, reported to the client with
+// status.
+type requestError struct {
+	status int
+	msg    string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func unsupportedMediaType(format string, args ...any) error {
+	return &requestError{status: http.StatusUnsupportedMediaType, msg: fmt.Sprintf(format, args...)}
+}
+
+func badRequest(format string, args ...any) error {
+	return &requestError{status: http.StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+// negotiate picks the offer the Accept header prefers. Every offer is rated
+// with the q-value of the most specific media range matching it, and ties go
+// to the earlier offer. An empty header accepts the first offer; false means
+// no offer is acceptable.
+func negotiate(accept string, offers []string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+
+	best, bestQ := "", 0.0
+
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(mediaRange)
+
+			if err != nil {
+				continue
+			}
+
+			if s := matchSpecificity(mediaType, offer); s > specificity {
+				specificity = s
+				q = parseQuality(params["q"])
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best, bestQ > 0
+}
+
+// matchSpecificity tells how closely mediaRange matches mediaType: 2 for the
+// same type, 1 for "type/*", 0 for "*/*" and -1 for no match.
+func matchSpecificity(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	default:
+		return -1
+	}
+}
+
+func parseQuality(value string) float64 {
+	if value == "" {
+		return 1
+	}
+
+	q, err := strconv.ParseFloat(value, 64)
+
+	if err != nil || q < 0 || q > 1 {
+		return 0
+	}
+
+	return q
+}
+
+// requestText returns the text to transform from a request body in any of
+// the supported formats: text/plain in any charset known to browsers, JSON
+// {"text": "..."}, a form with a "text" field, or a multipart upload whose
+// "file" (or "text") part holds the text.
+func requestText(r *http.Request) (io.Reader, error) {
+	contentType := r.Header.Get("Content-Type")
+
+	if contentType == "" {
+		return nil, unsupportedMediaType("the header Content-Type is missing")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return nil, unsupportedMediaType("the header Content-Type is malformed: %v", err)
+	}
+
+	switch mediaType {
+	case mediaText:
+		return decodeCharset(r.Body, params["charset"])
+	case mediaJSON:
+		return jsonText(r.Body, params["charset"])
+	case mediaForm:
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+
+		if !r.PostForm.Has("text") {
+			return nil, badRequest(`the form has no field "text"`)
+		}
+
+		return strings.NewReader(r.PostForm.Get("text")), nil
+	case mediaMultipart:
+		return multipartText(r)
+	default:
+		return nil, unsupportedMediaType("the Content-Type %v is not supported", mediaType)
+	}
+}
+
+// decodeCharset converts body from charset to UTF-8.
+func decodeCharset(body io.Reader, charset string) (io.Reader, error) {
+	if charset == "" || strings.EqualFold(charset, "utf-8") {
+		return body, nil
+	}
+
+	encoding, err := htmlindex.Get(charset)
+
+	if err != nil {
+		return nil, unsupportedMediaType("the charset %q is not supported", charset)
+	}
+
+	if name, _ := htmlindex.Name(encoding); name == "utf-8" {
+		return body, nil
+	}
+
+	return encoding.NewDecoder().Reader(body), nil
+}
+
+func jsonText(body io.Reader, charset string) (io.Reader, error) {
+	if charset != "" && !strings.EqualFold(charset, "utf-8") {
+		return nil, unsupportedMediaType("JSON must be encoded in UTF-8")
+	}
+
+	var payload struct {
+		Text *string `json:"text"`
+	}
+
+	if err := json.NewDecoder(body).Decode(&payload); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+
+		if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, badRequest("the JSON body is malformed: %v", err)
+		}
+
+		return nil, err
+	}
+
+	if payload.Text == nil {
+		return nil, badRequest(`the JSON body has no field "text"`)
+	}
+
+	return strings.NewReader(*payload.Text), nil
+}
+
+func multipartText(r *http.Request) (io.Reader, error) {
+	reader, err := r.MultipartReader()
+
+	if err != nil {
+		return nil, badRequest("the multipart body is malformed: %v", err)
+	}
+
+	for {
+		part, err := reader.NextPart()
+
+		if err == io.EOF {
+			return nil, badRequest(`the multipart body has no part "file" or "text"`)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if name := part.FormName(); name != "file" && name != "text" {
+			continue
+		}
+
+		charset := ""
+
+		if contentType := part.Header.Get("Content-Type"); contentType != "" {
+			mediaType, params, err := mime.ParseMediaType(contentType)
+
+			if err != nil || (mediaType != mediaText && mediaType != "application/octet-stream") {
+				return nil, unsupportedMediaType("the uploaded file must be text/plain")
+			}
+
+			charset = params["charset"]
+		}
+
+		return decodeCharset(part, charset)
+	}
+}
+
+// jsonTextWriter writes what it is given as the "text" string of a JSON
+// object. The opening is held back until the first write, so that an error
+// before any output can still change the status code.
+type jsonTextWriter struct {
+	w       io.Writer
+	started bool
+	buf     []byte
+}
+
+func (jw *jsonTextWriter) Write(p []byte) (int, error) {
+	jw.buf = jw.buf[:0]
+
+	if !jw.started {
+		jw.buf = append(jw.buf, `{"text":"`...)
+		jw.started = true
+	}
+
+	jw.buf = appendJSONEscaped(jw.buf, p)
+
+	if _, err := jw.w.Write(jw.buf); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close finishes the JSON object.
+func (jw *jsonTextWriter) Close() error {
+	closing := `"}` + "\n"
+
+	if !jw.started {
+		closing = `{"text":""}` + "\n"
+	}
+
+	_, err := io.WriteString(jw.w, closing)
+
+	return err
+}
+
+// appendJSONEscaped appends the UTF-8 text s escaped for the inside of a JSON
+// string. Invalid bytes become U+FFFD, as encoding/json does.
+func appendJSONEscaped(dst, s []byte) []byte {
+	const hex = "0123456789abcdef"
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+
+		switch {
+		case r == '"' || r == '\\':
+			dst = append(dst, '\\', byte(r))
+		case r == '\n':
+			dst = append(dst, '\\', 'n')
+		case r == '\r':
+			dst = append(dst, '\\', 'r')
+		case r == '\t':
+			dst = append(dst, '\\', 't')
+		case r < 0x20:
+			dst = append(dst, '\\', 'u', '0', '0', hex[r>>4], hex[r&0xf])
+		case r == utf8.RuneError && size == 1:
+			dst = append(dst, `\ufffd`...)
+		case r == '\u2028' || r == '\u2029':
+			// valid JSON, but not valid JavaScript
+			dst = append(dst, '\\', 'u', '2', '0', '2', hex[r&0xf])
+		default:
+			dst = append(dst, s[i:i+size]...)
+		}
+
+		i += size
+	}
+
+	return dst
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{mediaText, mediaJSON}
+
+	testCases := []struct {
+		name   string
+		accept string
+		want   string
+		ok     bool
+	}{
+		{name: "no header", accept: "", want: mediaText, ok: true},
+		{name: "anything", accept: "*/*", want: mediaText, ok: true},
+		{name: "json", accept: "application/json", want: mediaJSON, ok: true},
+		{name: "json preferred", accept: "text/plain;q=0.5, application/json", want: mediaJSON, ok: true},
+		{name: "type wildcard", accept: "application/*", want: mediaJSON, ok: true},
+		{name: "specific range wins", accept: "*/*;q=0.9, text/plain;q=0.1", want: mediaJSON, ok: true},
+		{name: "tie goes to first offer", accept: "application/json, text/plain", want: mediaText, ok: true},
+		{name: "excluded", accept: "text/plain;q=0, */*;q=0", ok: false},
+		{name: "unavailable", accept: "image/png", ok: false},
+		{name: "malformed range skipped", accept: "//, application/json", want: mediaJSON, ok: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// act
+			got, ok := negotiate(tc.accept, offers)
+
+			// assert
+			if ok != tc.ok || got != tc.want {
+				t.Errorf("got=%q %v, want=%q %v", got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func multipartBody(t *testing.T, field, filename, content string) (string, *bytes.Buffer) {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("note", "ignored")
+	part, err := mw.CreateFormFile(field, filename)
+
+	if err != nil {
+		t.Fatalf("unexpected multipart error: %v", err)
+	}
+
+	io.WriteString(part, content)
+	mw.Close()
+
+	return mw.FormDataContentType(), &body
+}
+
+func TestEndpointContentNegotiation(t *testing.T) {
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	handler := CapitalizeHandler(logger)
+	uploadType, upload := multipartBody(t, "file", "crab.txt", "ken jeong")
+
+	testCases := []struct {
+		name        string
+		contentType string
+		accept      string
+		input       string
+		status      int
+		want        string
+		wantType    string
+	}{
+		{name: "text with charset", contentType: "text/plain; charset=utf-8", input: "chow", status: http.StatusOK, want: "CHOW", wantType: "text/plain; charset=utf-8"},
+		{name: "latin-1 text", contentType: "text/plain; charset=iso-8859-1", input: "caf\xe9", status: http.StatusOK, want: "CAFÉ"},
+		{name: "unknown charset", contentType: "text/plain; charset=klingon", input: "chow", status: http.StatusUnsupportedMediaType},
+		{name: "json in, text out", contentType: "application/json", input: `{"text": "chow"}`, status: http.StatusOK, want: "CHOW"},
+		{name: "json in and out", contentType: "application/json", accept: "application/json", input: `{"text": "chow"}`, status: http.StatusOK, want: `{"text":"CHOW"}` + "\n", wantType: "application/json; charset=utf-8"},
+		{name: "json escaping", contentType: "text/plain", accept: "application/json", input: "say \"hi\"\n\x01", status: http.StatusOK, want: `{"text":"SAY \"HI\"\n\u0001"}` + "\n"},
+		{name: "empty json reply", contentType: "text/plain", accept: "application/json", input: "", status: http.StatusOK, want: `{"text":""}` + "\n"},
+		{name: "malformed json", contentType: "application/json", input: `{"text": `, status: http.StatusBadRequest},
+		{name: "json without text", contentType: "application/json", input: `{"name": "chow"}`, status: http.StatusBadRequest},
+		{name: "json error reply", contentType: "application/json", accept: "application/json", input: `[]`, status: http.StatusBadRequest, wantType: "application/json; charset=utf-8"},
+		{name: "form", contentType: mediaForm, input: url.Values{"text": {"mr chow"}}.Encode(), status: http.StatusOK, want: "MR CHOW"},
+		{name: "form without text", contentType: mediaForm, input: "name=chow", status: http.StatusBadRequest},
+		{name: "multipart upload", contentType: uploadType, input: upload.String(), status: http.StatusOK, want: "KEN JEONG"},
+		{name: "not acceptable", contentType: "text/plain", accept: "image/png", input: "chow", status: http.StatusNotAcceptable},
+		{name: "unsupported type", contentType: "application/xml", input: "<chow/>", status: http.StatusUnsupportedMediaType},
+		{name: "missing type", input: "chow", status: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			request := httptest.NewRequest(http.MethodPost, "/capitalize", strings.NewReader(tc.input))
+
+			if tc.contentType != "" {
+				request.Header.Set("Content-Type", tc.contentType)
+			}
+
+			if tc.accept != "" {
+				request.Header.Set("Accept", tc.accept)
+			}
+
+			rw := httptest.NewRecorder()
+
+			// act
+			handler(rw, request)
+
+			// assert
+			if rw.Code != tc.status {
+				t.Fatalf("status code: got=%v, want=%v (%v)", rw.Code, tc.status, rw.Body.String())
+			}
+
+			if got := rw.Body.String(); tc.want != "" && got != tc.want {
+				t.Errorf("response body: got=%q, want=%q", got, tc.want)
+			}
+
+			if got := rw.Header().Get("Content-Type"); tc.wantType != "" && got != tc.wantType {
+				t.Errorf("Content-Type: got=%v, want=%v", got, tc.wantType)
+			}
+		})
+	}
+}
+
+func TestEndpointJSONReplyIsValid(t *testing.T) {
+	// asset
+	handler := CapitalizeHandler(log.New(io.Discard, "", log.LstdFlags))
+	input := "quote \" backslash \\ tab \t bell \a invalid \xff separator \u2028 \u00df"
+	request := httptest.NewRequest(http.MethodPost, "/capitalize?mode=lower", strings.NewReader(input))
+	request.Header.Set("Content-Type", "text/plain")
+	request.Header.Set("Accept", "application/json")
+	rw := httptest.NewRecorder()
+
+	// act
+	handler(rw, request)
+
+	// assert
+	var reply struct {
+		Text string `json:"text"`
+	}
+
+	if err := json.Unmarshal(rw.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("the reply is not valid JSON: %v (%q)", err, rw.Body.String())
+	}
+
+	want := strings.ToValidUTF8(strings.ToLower(input), "\ufffd")
+
+	if reply.Text != want {
+		t.Errorf("got=%q, want=%q", reply.Text, want)
+	}
+
+	if strings.Contains(rw.Body.String(), "\u2028") {
+		t.Errorf("U+2028 was not escaped")
+	}
+}
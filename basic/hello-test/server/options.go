@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+)
+
+// Defaults applied by New. They keep a slow or malicious client from holding
+// a connection open forever (slowloris) while leaving enough room for normal
+// requests.
+const (
+	DefaultReadHeaderTimeout = 5 * time.Second
+	DefaultReadTimeout       = 30 * time.Second
+	DefaultWriteTimeout      = 30 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+	DefaultMaxHeaderBytes    = 64 << 10
+)
+
+// Option configures a Server created by New.
+type Option func(*Server)
+
+// NoTimeout turns a timeout of Timeouts off, e.g. Read and Write for
+// handlers that stream long bodies, such as CapitalizeHandler and
+// BatchHandler. Without ReadHeader, a client may hold a connection forever
+// before sending a request, so keep that one.
+const NoTimeout time.Duration = -1
+
+// Timeouts groups the per-connection timeouts of the underlying http.Server.
+// A zero field keeps the current value, and a negative one, e.g. NoTimeout,
+// turns the timeout off. As in http.Server, ReadHeader and Idle then fall
+// back to Read.
+type Timeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+// WithLogger replaces the default "[Going Crab]" logger writing to stderr.
+// The logger also receives the errors of the underlying http.Server.
+func WithLogger(logger *log.Logger) Option {
+	return func(ws *Server) {
+		ws.logger = logger
+	}
+}
+
+// WithTimeouts overrides the connection timeouts.
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(ws *Server) {
+		if timeouts.ReadHeader != 0 {
+			ws.timeouts.ReadHeader = timeouts.ReadHeader
+		}
+
+		if timeouts.Read != 0 {
+			ws.timeouts.Read = timeouts.Read
+		}
+
+		if timeouts.Write != 0 {
+			ws.timeouts.Write = timeouts.Write
+		}
+
+		if timeouts.Idle != 0 {
+			ws.timeouts.Idle = timeouts.Idle
+		}
+	}
+}
+
+// WithMaxHeaderBytes limits the size of the request line and headers.
+func WithMaxHeaderBytes(n int) Option {
+	return func(ws *Server) {
+		ws.maxHeaderBytes = n
+	}
+}
+
+// WithAddr listens on addr instead of ":<port>". Use it to bind a specific
+// interface, e.g. "127.0.0.1:8080", or to give the path of a unix socket.
+func WithAddr(addr string) Option {
+	return func(ws *Server) {
+		ws.addr = addr
+	}
+}
+
+// WithBaseContext sets the function that provides the base context of every
+// request accepted on the listener, see http.Server.BaseContext.
+func WithBaseContext(baseContext func(net.Listener) context.Context) Option {
+	return func(ws *Server) {
+		ws.baseContext = baseContext
+	}
+}
+
+// WithDrainTimeout changes how long Run lets in-flight requests drain before
+// the remaining connections are closed forcibly.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(ws *Server) {
+		ws.drainTimeout = d
+	}
+}
+
+// WithShutdownDelay makes Run report not ready on /readyz for d before it
+// stops accepting connections, so that load balancers probing the endpoint
+// take the server out of rotation while it still serves. Without it, new
+// connections are refused as soon as the shutdown starts.
+func WithShutdownDelay(d time.Duration) Option {
+	return func(ws *Server) {
+		ws.shutdownDelay = d
+	}
+}
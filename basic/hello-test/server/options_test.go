@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewDefaults(t *testing.T) {
+	// act
+	srv := New("tcp", 8080).httpServer()
+
+	// assert
+	if srv.Addr != ":8080" {
+		t.Errorf("Addr: got=%v, want=%v", srv.Addr, ":8080")
+	}
+
+	durations := []struct {
+		name string
+		got  time.Duration
+		want time.Duration
+	}{
+		{name: "ReadHeaderTimeout", got: srv.ReadHeaderTimeout, want: DefaultReadHeaderTimeout},
+		{name: "ReadTimeout", got: srv.ReadTimeout, want: DefaultReadTimeout},
+		{name: "WriteTimeout", got: srv.WriteTimeout, want: DefaultWriteTimeout},
+		{name: "IdleTimeout", got: srv.IdleTimeout, want: DefaultIdleTimeout},
+	}
+
+	for _, d := range durations {
+		if d.got != d.want {
+			t.Errorf("%v: got=%v, want=%v", d.name, d.got, d.want)
+		}
+	}
+
+	if srv.MaxHeaderBytes != DefaultMaxHeaderBytes {
+		t.Errorf("MaxHeaderBytes: got=%v, want=%v", srv.MaxHeaderBytes, DefaultMaxHeaderBytes)
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	// asset
+	logger := log.New(io.Discard, "", 0)
+	baseContext := func(net.Listener) context.Context { return context.Background() }
+
+	// act
+	srv := New(
+		"tcp", 8080,
+		WithLogger(logger),
+		WithAddr("127.0.0.1:9090"),
+		WithTimeouts(Timeouts{Read: time.Second, Idle: time.Minute}),
+		WithMaxHeaderBytes(4096),
+		WithBaseContext(baseContext),
+	).httpServer()
+
+	// assert
+	if srv.Addr != "127.0.0.1:9090" {
+		t.Errorf("Addr: got=%v, want=%v", srv.Addr, "127.0.0.1:9090")
+	}
+
+	if srv.ErrorLog != logger {
+		t.Errorf("ErrorLog is not the logger given by WithLogger")
+	}
+
+	if srv.ReadTimeout != time.Second || srv.IdleTimeout != time.Minute {
+		t.Errorf("overridden timeouts: got=(%v, %v), want=(%v, %v)", srv.ReadTimeout, srv.IdleTimeout, time.Second, time.Minute)
+	}
+
+	if srv.ReadHeaderTimeout != DefaultReadHeaderTimeout || srv.WriteTimeout != DefaultWriteTimeout {
+		t.Errorf("untouched timeouts lost their defaults: got=(%v, %v)", srv.ReadHeaderTimeout, srv.WriteTimeout)
+	}
+
+	if srv.MaxHeaderBytes != 4096 {
+		t.Errorf("MaxHeaderBytes: got=%v, want=%v", srv.MaxHeaderBytes, 4096)
+	}
+
+	if srv.BaseContext == nil {
+		t.Errorf("BaseContext was not set")
+	}
+}
+
+func TestNoTimeout(t *testing.T) {
+	// act
+	srv := New("tcp", 8080, WithTimeouts(Timeouts{Read: NoTimeout, Write: NoTimeout})).httpServer()
+
+	// assert
+	if srv.ReadTimeout != 0 || srv.WriteTimeout != 0 {
+		t.Errorf("turned off timeouts: got=(%v, %v), want=(0, 0)", srv.ReadTimeout, srv.WriteTimeout)
+	}
+
+	if srv.ReadHeaderTimeout != DefaultReadHeaderTimeout || srv.IdleTimeout != DefaultIdleTimeout {
+		t.Errorf("untouched timeouts lost their defaults: got=(%v, %v)", srv.ReadHeaderTimeout, srv.IdleTimeout)
+	}
+}
+
+func TestSlowHeadersAreCutOff(t *testing.T) {
+	// asset
+	type ctxKey struct{}
+
+	var logs bytes.Buffer
+	baseContext := func(net.Listener) context.Context {
+		return context.WithValue(context.Background(), ctxKey{}, "crab")
+	}
+
+	srv := New(
+		"tcp", 0,
+		WithAddr("127.0.0.1:0"),
+		WithLogger(log.New(&logs, "", 0)),
+		WithTimeouts(Timeouts{ReadHeader: 100 * time.Millisecond}),
+		WithBaseContext(baseContext),
+	)
+	srv.RegisterEndpoint("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Context().Value(ctxKey{}))
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- srv.Run(ctx)
+	}()
+
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	addr := waitReady(t, srv, done)
+
+	// act
+	conn, err := net.Dial("tcp", addr)
+
+	if err != nil {
+		t.Fatalf("unexpected dial error: %v", err)
+	}
+
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: crab\r\n")) // never finish the headers
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadAll(conn)
+
+	// assert
+	if err != nil {
+		t.Errorf("slow client was not disconnected by the server: %v", err)
+	}
+
+	response, err := http.Get("http://" + addr + "/")
+
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+
+	if got := strings.TrimSpace(string(body)); got != "crab" {
+		t.Errorf("value from the base context: got=%v, want=%v", got, "crab")
+	}
+}
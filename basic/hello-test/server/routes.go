@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrUnknownRoute is returned by UnregisterEndpoint for a pattern that is not
+// registered.
+var ErrUnknownRoute = errors.New("unknown route")
+
+type route struct {
+	pattern string
+	handler http.Handler
+}
+
+// routeTable dispatches requests to the registered endpoints. An
+// http.ServeMux cannot forget a pattern, so every change builds a new mux
+// from the table and swaps it in: requests in flight finish on the mux they
+// started with, new ones see the change. The zero value has no routes.
+type routeTable struct {
+	mu     sync.Mutex // serializes changes
+	routes []route
+	mux    atomic.Pointer[http.ServeMux]
+}
+
+func (rt *routeTable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mux := rt.mux.Load()
+
+	if mux == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	mux.ServeHTTP(w, r)
+}
+
+// swap serves routes from now on, unless they contain an invalid or
+// conflicting pattern.
+func (rt *routeTable) swap(routes []route) (err error) {
+	mux := http.NewServeMux()
+
+	// ServeMux reports bad patterns by panicking
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	for _, rte := range routes {
+		mux.Handle(rte.pattern, rte.handler)
+	}
+
+	rt.routes = routes
+	rt.mux.Store(mux)
+
+	return nil
+}
+
+// RegisterEndpoint routes pattern to handler, wrapped in the endpoint
+// middleware mws. See Middleware for the order in which middleware runs.
+//
+// It is safe to call while Run is serving: the endpoint is live for every
+// request that arrives after it returns. It fails on a pattern ServeMux does
+// not accept or that conflicts with a registered one.
+func (ws *Server) RegisterEndpoint(pattern string, handler http.Handler, mws ...Middleware) error {
+	handler = chain(handler, mws)
+
+	ws.routes.mu.Lock()
+	defer ws.routes.mu.Unlock()
+
+	routes := append(slices.Clip(ws.routes.routes), route{pattern: pattern, handler: handler})
+
+	if err := ws.routes.swap(routes); err != nil {
+		return fmt.Errorf("register %q: %w", pattern, err)
+	}
+
+	return nil
+}
+
+// UnregisterEndpoint removes the endpoint registered with pattern, e.g. to
+// switch off a feature while serving. Requests already running finish
+// normally; later ones get 404 Not Found, or whatever route matches them
+// next.
+func (ws *Server) UnregisterEndpoint(pattern string) error {
+	ws.routes.mu.Lock()
+	defer ws.routes.mu.Unlock()
+
+	i := slices.IndexFunc(ws.routes.routes, func(rte route) bool {
+		return rte.pattern == pattern
+	})
+
+	if i < 0 {
+		return fmt.Errorf("unregister %q: %w", pattern, ErrUnknownRoute)
+	}
+
+	return ws.routes.swap(slices.Delete(slices.Clone(ws.routes.routes), i, i+1))
+}
+
+// Routes returns the patterns of the registered endpoints, in the order they
+// were registered.
+func (ws *Server) Routes() []string {
+	ws.routes.mu.Lock()
+	defer ws.routes.mu.Unlock()
+
+	patterns := make([]string, len(ws.routes.routes))
+
+	for i, rte := range ws.routes.routes {
+		patterns[i] = rte.pattern
+	}
+
+	return patterns
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func text(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	})
+}
+
+func get(handler http.Handler, target string) (int, string) {
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, target, nil))
+
+	return rw.Code, rw.Body.String()
+}
+
+func TestUnregisterEndpoint(t *testing.T) {
+	// asset
+	srv := New("tcp", 0)
+	handler := srv.httpServer().Handler
+	srv.RegisterEndpoint("/feature", text("on"))
+	srv.Group("/v1").RegisterEndpoint("GET /feature", text("v1"))
+
+	if code, body := get(handler, "/feature"); code != http.StatusOK || body != "on" {
+		t.Fatalf("before unregistering: got=(%v, %q), want=(%v, %q)", code, body, http.StatusOK, "on")
+	}
+
+	// act
+	err := srv.UnregisterEndpoint("/feature")
+	groupErr := srv.Group("/v1").UnregisterEndpoint("GET /feature")
+
+	// assert
+	if err != nil || groupErr != nil {
+		t.Fatalf("unexpected unregister errors: %v, %v", err, groupErr)
+	}
+
+	for _, target := range []string{"/feature", "/v1/feature"} {
+		if code, _ := get(handler, target); code != http.StatusNotFound {
+			t.Errorf("%v after unregistering: got=%v, want=%v", target, code, http.StatusNotFound)
+		}
+	}
+
+	if err := srv.UnregisterEndpoint("/feature"); !errors.Is(err, ErrUnknownRoute) {
+		t.Errorf("unregistering twice: got=%v, want=%v", err, ErrUnknownRoute)
+	}
+
+	if want := []string{"GET " + LivenessPath, "GET " + ReadinessPath}; !slices.Equal(srv.Routes(), want) {
+		t.Errorf("Routes: got=%v, want=%v", srv.Routes(), want)
+	}
+
+	// the pattern is free again
+	if err := srv.RegisterEndpoint("/feature", text("back")); err != nil {
+		t.Errorf("registering again: unexpected error: %v", err)
+	}
+}
+
+func TestRegisterEndpointErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		pattern string
+	}{
+		{name: "duplicate", pattern: "/capitalize"},
+		{name: "conflict", pattern: "/files/{name...}"},
+		{name: "invalid", pattern: "/{crab"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			srv := New("tcp", 0)
+			srv.RegisterEndpoint("/capitalize", text("first"))
+			srv.RegisterEndpoint("/files/{path...}", text("file"))
+
+			// act
+			err := srv.RegisterEndpoint(tc.pattern, text("second"))
+
+			// assert
+			if err == nil {
+				t.Fatalf("registering %q succeeded", tc.pattern)
+			}
+
+			if code, body := get(srv.httpServer().Handler, "/capitalize"); code != http.StatusOK || body != "first" {
+				t.Errorf("the routes changed after a failed register: got=(%v, %q)", code, body)
+			}
+
+			if got := len(srv.Routes()); got != 4 {
+				t.Errorf("number of routes: got=%v, want=%v", got, 4)
+			}
+		})
+	}
+}
+
+func TestUnregisterLetsInFlightRequestsFinish(t *testing.T) {
+	// asset
+	srv := New("tcp", 0, WithAddr("127.0.0.1:0"), WithLogger(log.New(io.Discard, "", 0)))
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	srv.RegisterEndpoint("/slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "finished")
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- srv.Run(ctx)
+	}()
+
+	addr := waitReady(t, srv, done)
+	result := getSlow(addr)
+	<-started
+
+	// act
+	srv.UnregisterEndpoint("/slow")
+	close(release)
+
+	// assert
+	if got := <-result; got.err != nil || got.body != "finished" {
+		t.Errorf("in-flight request: got=(%q, %v), want=(%q, nil)", got.body, got.err, "finished")
+	}
+
+	if code, _ := get(srv.httpServer().Handler, "/slow"); code != http.StatusNotFound {
+		t.Errorf("new request: got=%v, want=%v", code, http.StatusNotFound)
+	}
+}
+
+// TestRouteChangesWhileServing is meant for the race detector: routes come
+// and go while requests are served, and the route that stays must never fail.
+func TestRouteChangesWhileServing(t *testing.T) {
+	// asset
+	srv := New("tcp", 0)
+	srv.RegisterEndpoint("GET /stable", text("stable"))
+	handler := srv.httpServer().Handler
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	for range 4 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				if code, body := get(handler, "/stable"); code != http.StatusOK || body != "stable" {
+					t.Errorf("stable route: got=(%v, %q)", code, body)
+					return
+				}
+
+				if code, _ := get(handler, "/flaky"); code != http.StatusOK && code != http.StatusNotFound {
+					t.Errorf("flaky route: got=%v", code)
+					return
+				}
+			}
+		}()
+	}
+
+	// act
+	for i := range 200 {
+		pattern := fmt.Sprintf("/route/%v", i)
+
+		if err := srv.RegisterEndpoint(pattern, text(pattern)); err != nil {
+			t.Fatalf("unexpected register error: %v", err)
+		}
+
+		srv.RegisterEndpoint("/flaky", text("flaky"))
+		srv.Routes()
+		srv.UnregisterEndpoint("/flaky")
+	}
+
+	close(stop)
+	wg.Wait()
+
+	// assert
+	if got := len(srv.Routes()); got != 203 {
+		t.Errorf("number of routes: got=%v, want=%v", got, 203)
+	}
+
+	if code, body := get(handler, "/route/199"); code != http.StatusOK || body != "/route/199" {
+		t.Errorf("last registered route: got=(%v, %q)", code, body)
+	}
+}
@@ -1,52 +1,279 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// DefaultDrainTimeout is how long Run waits for in-flight requests to finish
+// once a shutdown has been requested.
+const DefaultDrainTimeout = 15 * time.Second
+
 type Server struct {
-	protocol string
-	port     int
-	mux      *http.ServeMux
-	logger   *log.Logger
+	protocol       string
+	port           int
+	addr           string
+	routes         routeTable
+	middleware     []Middleware
+	logger         *log.Logger
+	timeouts       Timeouts
+	maxHeaderBytes int
+	baseContext    func(net.Listener) context.Context
+	drainTimeout   time.Duration
+	shutdownDelay  time.Duration
+	tls            *tlsFiles
+	socketMode     os.FileMode
+	socketUID      int
+	socketGID      int
+	activation     bool
+	health         *health
+
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	extraListeners []Listener
+
+	h2c                  bool
+	maxConcurrentStreams int
+	upgrader             *h2cUpgrader // set by Run with h2c
+
+	// set by Run once it listens
+	mu    sync.Mutex
+	bound []boundListener
 }
 
-func New(protocol string, port int) *Server {
-	mux := http.NewServeMux()
+// New returns a Server for protocol, e.g. "tcp" or "unix", listening on port.
+// Port 0 picks a free port when Run starts; Addr tells which one.
+func New(protocol string, port int, opts ...Option) *Server {
 	logger := log.New(
 		os.Stderr,
 		"[Going Crab] ",
 		log.LstdFlags|log.Lshortfile|log.LUTC,
 	)
 
-	return &Server{
+	ws := &Server{
 		protocol: protocol,
 		port:     port,
-		mux:      mux,
 		logger:   logger,
+		timeouts: Timeouts{
+			ReadHeader: DefaultReadHeaderTimeout,
+			Read:       DefaultReadTimeout,
+			Write:      DefaultWriteTimeout,
+			Idle:       DefaultIdleTimeout,
+		},
+		maxHeaderBytes: DefaultMaxHeaderBytes,
+		drainTimeout:   DefaultDrainTimeout,
+		socketUID:      -1,
+		socketGID:      -1,
+		health:         &health{},
+		ready:          make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(ws)
 	}
+
+	// the table is empty still, so these cannot conflict
+	ws.RegisterEndpoint("GET "+LivenessPath, ws.health.livenessHandler())
+	ws.RegisterEndpoint("GET "+ReadinessPath, ws.health.readinessHandler())
+
+	return ws
+}
+
+// Ready returns a channel that is closed once Run listens for the first time.
+func (ws *Server) Ready() <-chan struct{} {
+	return ws.ready
 }
 
-func (ws *Server) RegisterEndpoint(path string, handler http.Handler) {
-	ws.mux.Handle(path, handler)
+// Addr returns the address Run listens on, or nil before it does. With port 0
+// it holds the port that was picked. The addresses of the listeners added
+// with WithListener are in Addrs.
+func (ws *Server) Addr() net.Addr {
+	if addrs := ws.Addrs(); len(addrs) > 0 {
+		return addrs[0]
+	}
+
+	return nil
 }
 
-func (ws *Server) Run() error {
-	srv := &http.Server{}
-	srv.Addr = fmt.Sprintf(":%v", ws.port)
-	srv.Handler = ws.mux
+// Addrs returns the addresses of all listeners, Addr first and then those
+// added with WithListener in their order, or nil before Run listens.
+func (ws *Server) Addrs() []net.Addr {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	addrs := make([]net.Addr, len(ws.bound))
 
-	listener, err := net.Listen(ws.protocol, srv.Addr)
+	for i, b := range ws.bound {
+		addrs[i] = b.listener.Addr()
+	}
+
+	return addrs
+}
+
+// listening records where Run listens and announces that it is ready.
+func (ws *Server) listening(bound []boundListener) {
+	ws.mu.Lock()
+	ws.bound = bound
+	ws.mu.Unlock()
+
+	ws.readyOnce.Do(func() {
+		close(ws.ready)
+	})
+}
+
+// address is the address Run listens on when neither a unix socket nor socket
+// activation is used.
+func (ws *Server) address() string {
+	if ws.addr != "" {
+		return ws.addr
+	}
+
+	return fmt.Sprintf(":%v", ws.port)
+}
+
+// httpServer builds the http.Server that Run serves with, wrapping the routes
+// in the global middleware.
+func (ws *Server) httpServer() *http.Server {
+	return ws.httpServerWith(ws.address(), ws.middleware)
+}
+
+// httpServerWith builds an http.Server for one listener, wrapping the routes
+// in mws.
+func (ws *Server) httpServerWith(addr string, mws []Middleware) *http.Server {
+	handler := chain(&ws.routes, mws)
+
+	if ws.upgrader != nil {
+		handler = ws.upgrader.wrap(handler)
+	}
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: max(ws.timeouts.ReadHeader, 0),
+		ReadTimeout:       max(ws.timeouts.Read, 0),
+		WriteTimeout:      max(ws.timeouts.Write, 0),
+		IdleTimeout:       max(ws.timeouts.Idle, 0),
+		MaxHeaderBytes:    ws.maxHeaderBytes,
+		BaseContext:       ws.baseContext,
+		ErrorLog:          ws.logger,
+		Protocols:         ws.protocols(),
+		HTTP2:             ws.http2Config(),
+	}
+}
+
+// Run serves requests on all listeners until ctx is cancelled or the process
+// receives SIGINT or SIGTERM. It then reports not ready on /readyz, keeps
+// serving for the shutdown delay, stops accepting new connections and waits
+// up to the drain timeout for in-flight requests to finish. A clean stop
+// returns nil.
+//
+// If a listener fails, the others are shut down the same way, and Run
+// returns the errors of all of them joined.
+func (ws *Server) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ws.health.draining.Store(false)
+
+	if ws.h2c {
+		ws.upgrader = newH2CUpgrader(ws.maxConcurrentStreams)
+	}
+
+	bound, err := ws.openListeners()
 
 	if err != nil {
-		return err // unexpected error
+		return err
+	}
+
+	defer func() {
+		for _, b := range bound {
+			b.listener.Close()
+		}
+	}()
+
+	ws.listening(bound)
+	serveErrs := make(chan error, len(bound))
+
+	for _, b := range bound {
+		go func() {
+			serveErrs <- b.serve()
+		}()
+	}
+
+	var errs []error
+	running := len(bound)
+
+	select {
+	case err := <-serveErrs:
+		// nothing has been shut down yet, so this listener failed
+		running--
+		errs = append(errs, err)
+		ws.logger.Printf("%v; shutting down, draining connections for up to %v", err, ws.drainTimeout)
+	case <-ctx.Done():
+		ws.logger.Printf("shutting down, draining connections for up to %v", ws.drainTimeout)
+	}
+
+	ws.health.draining.Store(true)
+
+	if ws.shutdownDelay > 0 {
+		ws.logger.Printf("reporting not ready for %v before closing the listeners", ws.shutdownDelay)
+		time.Sleep(ws.shutdownDelay)
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), ws.drainTimeout)
+	defer cancel()
+
+	errs = append(errs, shutdown(drainCtx, bound, ws.upgrader)...)
+
+	for range running {
+		if err := <-serveErrs; !errors.Is(err, http.ErrServerClosed) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// shutdown drains all servers, and the connections upgrader switched to h2c
+// if it is not nil, at the same time and closes those that do not finish
+// before ctx expires.
+func shutdown(ctx context.Context, bound []boundListener, upgrader *h2cUpgrader) []error {
+	errs := make([]error, len(bound)+1)
+	var wg sync.WaitGroup
+
+	if upgrader != nil {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			errs[len(bound)] = upgrader.shutdown(ctx)
+		}()
+	}
+
+	for i, b := range bound {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := b.srv.Shutdown(ctx); err != nil {
+				b.srv.Close() // drop the connections that did not finish in time
+				errs[i] = fmt.Errorf("drain connections on %v: %w", b.listener.Addr(), err)
+			}
+		}()
 	}
 
-	defer listener.Close()
+	wg.Wait()
 
-	return srv.Serve(listener) // server.Serve always returns an error
+	return errs
 }
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// waitReady waits until srv listens and returns its address. done receives
+// what Run returns, so that a server that fails to start ends the test.
+func waitReady(t *testing.T, srv *Server, done chan error) string {
+	t.Helper()
+
+	select {
+	case <-srv.Ready():
+		return srv.Addr().String()
+	case err := <-done:
+		done <- err // for a cleanup that waits for Run
+		t.Fatalf("server did not start: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server never started listening")
+	}
+
+	return ""
+}
+
+// waitRefusing polls addr until it stops accepting connections.
+func waitRefusing(t *testing.T, addr string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", addr)
+
+		if err != nil {
+			return
+		}
+
+		conn.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("server at %v kept accepting connections", addr)
+}
+
+// slowServer starts a Server whose /slow endpoint blocks until release is
+// closed. started receives a value once the handler is running.
+func slowServer(t *testing.T, ctx context.Context) (addr string, started chan struct{}, release chan struct{}, done chan error) {
+	t.Helper()
+
+	started = make(chan struct{}, 1)
+	release = make(chan struct{})
+	done = make(chan error, 1)
+
+	srv := New("tcp", 0, WithAddr("127.0.0.1:0"), WithDrainTimeout(5*time.Second))
+	srv.RegisterEndpoint("/slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("finished"))
+	}))
+
+	go func() {
+		done <- srv.Run(ctx)
+	}()
+
+	return waitReady(t, srv, done), started, release, done
+}
+
+type slowResult struct {
+	body string
+	err  error
+}
+
+func getSlow(addr string) chan slowResult {
+	result := make(chan slowResult, 1)
+
+	go func() {
+		response, err := http.Get("http://" + addr + "/slow")
+
+		if err != nil {
+			result <- slowResult{err: err}
+			return
+		}
+
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		result <- slowResult{body: string(body), err: err}
+	}()
+
+	return result
+}
+
+func TestRunDrainsInFlightRequests(t *testing.T) {
+	// asset
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addr, started, release, done := slowServer(t, ctx)
+	result := getSlow(addr)
+	<-started
+
+	// act
+	cancel()
+
+	// assert
+	waitRefusing(t, addr)
+	close(release)
+
+	got := <-result
+
+	if got.err != nil {
+		t.Fatalf("in-flight request failed: %v", got.err)
+	}
+
+	if got.body != "finished" {
+		t.Errorf("in-flight response body: got=%v, want=%v", got.body, "finished")
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("Run after clean shutdown: got=%v, want=nil", err)
+	}
+}
+
+func TestRunStopsOnSIGTERM(t *testing.T) {
+	// asset
+	addr, started, release, done := slowServer(t, context.Background())
+	result := getSlow(addr)
+	<-started
+
+	// act
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("unexpected kill error: %v", err)
+	}
+
+	// assert
+	waitRefusing(t, addr)
+	close(release)
+
+	if got := <-result; got.err != nil || got.body != "finished" {
+		t.Errorf("in-flight request: got=(%q, %v), want=(%q, nil)", got.body, got.err, "finished")
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("Run after SIGTERM: got=%v, want=nil", err)
+	}
+}
+
+func TestRunDrainTimeout(t *testing.T) {
+	// asset
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	srv := New("tcp", 0, WithAddr("127.0.0.1:0"), WithDrainTimeout(50*time.Millisecond))
+	srv.RegisterEndpoint("/stuck", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- srv.Run(ctx)
+	}()
+
+	addr := waitReady(t, srv, done)
+	go http.Get("http://" + addr + "/stuck")
+	<-started
+
+	// act
+	cancel()
+
+	// assert
+	err := <-done
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run with a stuck request: got=%v, want=%v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,129 @@
+// Package servertest runs a real server.Server inside a test, the way
+// net/http/httptest does for a plain http.Handler. Unlike httptest.NewServer
+// it goes through everything Run sets up: middleware, health endpoints,
+// timeouts, TLS and unix sockets.
+package servertest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"hello-test/server"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// Server is a running server.Server and a client to talk to it.
+type Server struct {
+	*server.Server
+
+	// URL is the base URL of the server, e.g. http://127.0.0.1:49152, with
+	// no trailing slash. For a unix socket the host is "unix".
+	URL string
+
+	// Client sends requests to the server. It trusts the server's
+	// certificate and dials the socket for unix servers.
+	Client *http.Client
+}
+
+// Start runs srv until the test and its subtests finish, and returns once it
+// accepts connections. Create srv with port 0 (or WithAddr("127.0.0.1:0"))
+// so that tests can run in parallel. Start fails the test if Run fails, and
+// the cleanup reports an unclean shutdown.
+func Start(t testing.TB, srv *server.Server) *Server {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- srv.Run(ctx)
+	}()
+
+	select {
+	case <-srv.Ready():
+	case err := <-done:
+		cancel()
+		t.Fatalf("servertest: server did not start: %v", err)
+	}
+
+	ts := &Server{Server: srv}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	ts.Client = &http.Client{Transport: transport}
+
+	t.Cleanup(func() {
+		transport.CloseIdleConnections()
+		cancel()
+
+		if err := <-done; err != nil {
+			t.Errorf("servertest: server did not shut down cleanly: %v", err)
+		}
+	})
+
+	scheme := "http"
+
+	if tlsConfig := srv.TLSConfig(); tlsConfig != nil {
+		scheme = "https"
+		transport.TLSClientConfig = trustServer(t, tlsConfig)
+	}
+
+	switch addr := srv.Addr(); addr.Network() {
+	case "unix":
+		ts.URL = scheme + "://unix"
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", addr.String())
+		}
+	default:
+		ts.URL = scheme + "://" + dialable(addr)
+	}
+
+	return ts
+}
+
+// trustServer returns a client configuration that trusts the certificate the
+// server presents, which is usually self-signed.
+func trustServer(t testing.TB, serverConfig *tls.Config) *tls.Config {
+	t.Helper()
+
+	cert, err := serverConfig.GetCertificate(&tls.ClientHelloInfo{})
+
+	if err != nil {
+		t.Fatalf("servertest: no server certificate: %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+
+	if err != nil {
+		t.Fatalf("servertest: malformed server certificate: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	clientConfig := &tls.Config{RootCAs: roots}
+
+	// a certificate for names only is checked against the first of them
+	if len(leaf.IPAddresses) == 0 && len(leaf.DNSNames) > 0 {
+		clientConfig.ServerName = leaf.DNSNames[0]
+	}
+
+	return clientConfig
+}
+
+// dialable turns a listening address such as [::]:8080 into one a client
+// can connect to.
+func dialable(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+
+	if err != nil {
+		return addr.String()
+	}
+
+	// a wildcard listener on "tcp" accepts IPv4 too, even when it shows [::]
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,88 @@
+package servertest
+
+import (
+	"fmt"
+	"hello-test/server"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func helloServer(network string, opts ...server.Option) *server.Server {
+	opts = append([]server.Option{server.WithLogger(log.New(io.Discard, "", 0))}, opts...)
+	srv := server.New(network, 0, opts...)
+	srv.RegisterEndpoint("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello over %v", r.TLS != nil)
+	}))
+
+	return srv
+}
+
+func TestStart(t *testing.T) {
+	testCases := []struct {
+		name    string
+		network string
+		opts    []server.Option
+		want    string
+	}{
+		{name: "ephemeral port", network: "tcp", want: "hello over false"},
+		{name: "loopback", network: "tcp", opts: []server.Option{server.WithAddr("127.0.0.1:0")}, want: "hello over false"},
+		{name: "development certificate", network: "tcp", opts: []server.Option{server.WithTLS("", "")}, want: "hello over true"},
+		{name: "unix socket", network: "unix", want: "hello over false"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// asset
+			opts := tc.opts
+
+			if tc.network == "unix" {
+				opts = append(opts, server.WithAddr(filepath.Join(t.TempDir(), "crab.sock")))
+			}
+
+			ts := Start(t, helloServer(tc.network, opts...))
+
+			// act
+			response, err := ts.Client.Get(ts.URL + "/")
+
+			// assert
+			if err != nil {
+				t.Fatalf("unexpected client error: %v", err)
+			}
+
+			defer response.Body.Close()
+			body, _ := io.ReadAll(response.Body)
+
+			if got := string(body); got != tc.want {
+				t.Errorf("got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStartPicksDistinctPorts(t *testing.T) {
+	// act
+	first := Start(t, helloServer("tcp"))
+	second := Start(t, helloServer("tcp"))
+
+	// assert
+	if first.URL == second.URL {
+		t.Errorf("two servers share %v", first.URL)
+	}
+
+	response, err := second.Client.Get(second.URL + server.LivenessPath)
+
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("status code: got=%v, want=%v", response.StatusCode, http.StatusOK)
+	}
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tlsFiles holds the certificate and key configured by WithTLS. Both empty
+// means "generate a self-signed development certificate".
+type tlsFiles struct {
+	certFile string
+	keyFile  string
+}
+
+// WithTLS makes Run serve HTTPS. certFile and keyFile are PEM files; they are
+// checked every 30 seconds and re-read when they change on disk, so a
+// renewed certificate is picked up without a restart. When both are empty, a
+// self-signed certificate for localhost is generated at startup, which is
+// only good for development.
+func WithTLS(certFile, keyFile string) Option {
+	return func(ws *Server) {
+		ws.tls = &tlsFiles{certFile: certFile, keyFile: keyFile}
+	}
+}
+
+// newTLSConfig returns the modern TLS settings used for every HTTPS listener:
+// TLS 1.2 or later, forward-secret AEAD cipher suites only, and ALPN offering
+// HTTP/2 before HTTP/1.1.
+func newTLSConfig(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// only consulted for TLS 1.2, the TLS 1.3 suites are not configurable
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: getCertificate,
+	}
+}
+
+// tlsConfig builds the TLS configuration described by files.
+func (files *tlsFiles) tlsConfig(logger *log.Logger) (*tls.Config, error) {
+	if files.certFile == "" && files.keyFile == "" {
+		certPEM, keyPEM, err := selfSignedPEM([]string{"localhost", "127.0.0.1", "::1"}, 24*time.Hour)
+
+		if err != nil {
+			return nil, fmt.Errorf("generate development certificate: %w", err)
+		}
+
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Println("no TLS certificate configured, serving a self-signed development certificate")
+
+		return newTLSConfig(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &cert, nil
+		}), nil
+	}
+
+	reloader, err := newCertReloader(files.certFile, files.keyFile, certCheckInterval, logger)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newTLSConfig(reloader.GetCertificate), nil
+}
+
+// certCheckInterval is how long a loaded certificate is served before the
+// files are looked at again. Renewals happen days ahead of expiry, so there
+// is no point in paying for the check on every handshake.
+const certCheckInterval = 30 * time.Second
+
+// certReloader serves a certificate from disk and reloads it once the files
+// change, e.g. after a renewal by certbot or cert-manager.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	logger   *log.Logger
+
+	cert atomic.Pointer[tls.Certificate]
+
+	// mu is held by the one handshake that checks the files; the fields
+	// below belong to it
+	mu       sync.Mutex
+	checked  time.Time
+	certStat fileStamp
+	keyStat  fileStamp
+}
+
+// fileStamp is what we compare to notice that a file has been replaced.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stamp(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return fileStamp{}, err
+	}
+
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+func newCertReloader(certFile, keyFile string, interval time.Duration, logger *log.Logger) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval, logger: logger}
+
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// reload reads the key pair if either file changed since the last load.
+// The caller must hold cr.mu, except during construction.
+func (cr *certReloader) reload() error {
+	cr.checked = time.Now()
+	certStat, err := stamp(cr.certFile)
+
+	if err != nil {
+		return err
+	}
+
+	keyStat, err := stamp(cr.keyFile)
+
+	if err != nil {
+		return err
+	}
+
+	if cr.cert.Load() != nil && certStat == cr.certStat && keyStat == cr.keyStat {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+
+	if err != nil {
+		return fmt.Errorf("load TLS key pair: %w", err)
+	}
+
+	cr.cert.Store(&cert)
+	cr.certStat = certStat
+	cr.keyStat = keyStat
+
+	return nil
+}
+
+// GetCertificate is the tls.Config.GetCertificate callback. It checks the
+// files at most once per interval, and only one handshake does so while the
+// others go on with the current certificate. If the files on disk are
+// unreadable or half-written, it keeps serving the last good certificate and
+// retries after the next interval.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cr.mu.TryLock() {
+		if time.Since(cr.checked) >= cr.interval {
+			if err := cr.reload(); err != nil {
+				cr.logger.Printf("keeping the previous TLS certificate: %v", err)
+			}
+		}
+
+		cr.mu.Unlock()
+	}
+
+	return cr.cert.Load(), nil
+}
+
+// selfSignedPEM generates an ECDSA P-256 certificate valid for hosts, which
+// may be DNS names or IP addresses.
+func selfSignedPEM(hosts []string, validFor time.Duration) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Going Crab Development"}},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var certBuf, keyBuf bytes.Buffer
+	pem.Encode(&certBuf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&keyBuf, &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	return certBuf.Bytes(), keyBuf.Bytes(), nil
+}
+
+// TLSConfig returns the TLS configuration Run serves Addr with, or nil before
+// Run listens or without WithTLS. Its GetCertificate hands out the
+// certificate in use, which lets tests trust a development certificate.
+func (ws *Server) TLSConfig() *tls.Config {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if len(ws.bound) == 0 {
+		return nil
+	}
+
+	return ws.bound[0].tlsConfig
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKeyPair generates a certificate for localhost and stores it in dir.
+// The file times are set to modTime, so that a rewrite is always noticed.
+func writeKeyPair(t *testing.T, dir string, modTime time.Time) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+
+	certPEM, keyPEM, err := selfSignedPEM([]string{"localhost", "127.0.0.1"}, time.Hour)
+
+	if err != nil {
+		t.Fatalf("unexpected certificate error: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+
+	for file, data := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+		if err := os.WriteFile(file, data, 0o600); err != nil {
+			t.Fatalf("unexpected write error: %v", err)
+		}
+
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatalf("unexpected chtimes error: %v", err)
+		}
+	}
+
+	block, _ := pem.Decode(certPEM)
+	cert, err = x509.ParseCertificate(block.Bytes)
+
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+
+	return certFile, keyFile, cert
+}
+
+func peerCertificate(t *testing.T, client *http.Client, url string) *x509.Certificate {
+	t.Helper()
+
+	client.CloseIdleConnections() // force a new handshake
+	response, err := client.Get(url)
+
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	return response.TLS.PeerCertificates[0]
+}
+
+func TestCertificateHotReload(t *testing.T) {
+	// asset
+	dir := t.TempDir()
+	now := time.Now()
+	certFile, keyFile, first := writeKeyPair(t, dir, now)
+
+	// no interval, so that the files are checked on every handshake
+	reloader, err := newCertReloader(certFile, keyFile, 0, log.New(io.Discard, "", 0))
+
+	if err != nil {
+		t.Fatalf("unexpected reloader error: %v", err)
+	}
+
+	testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	testServer.TLS = newTLSConfig(reloader.GetCertificate)
+	testServer.StartTLS()
+	defer testServer.Close()
+
+	// the client has to send SNI, otherwise the httptest certificate wins
+	client := testServer.Client()
+	clientTLS := client.Transport.(*http.Transport).TLSClientConfig
+	clientTLS.ServerName = "localhost"
+	clientTLS.RootCAs.AddCert(first)
+
+	if got := peerCertificate(t, client, testServer.URL); !got.Equal(first) {
+		t.Fatalf("initial certificate: got serial %v, want %v", got.SerialNumber, first.SerialNumber)
+	}
+
+	// act
+	_, _, second := writeKeyPair(t, dir, now.Add(time.Minute))
+	clientTLS.RootCAs.AddCert(second)
+
+	// assert
+	if got := peerCertificate(t, client, testServer.URL); !got.Equal(second) {
+		t.Errorf("certificate after renewal: got serial %v, want %v", got.SerialNumber, second.SerialNumber)
+	}
+}
+
+func TestCertificateReloadKeepsLastGoodPair(t *testing.T) {
+	// asset
+	dir := t.TempDir()
+	var logs bytes.Buffer
+	certFile, keyFile, first := writeKeyPair(t, dir, time.Now())
+
+	reloader, err := newCertReloader(certFile, keyFile, 0, log.New(&logs, "", 0))
+
+	if err != nil {
+		t.Fatalf("unexpected reloader error: %v", err)
+	}
+
+	// act
+	os.WriteFile(keyFile, []byte("half written"), 0o600)
+	got, err := reloader.GetCertificate(&tls.ClientHelloInfo{})
+
+	// assert
+	if err != nil {
+		t.Fatalf("unexpected certificate error: %v", err)
+	}
+
+	leaf, _ := x509.ParseCertificate(got.Certificate[0])
+
+	if !leaf.Equal(first) {
+		t.Errorf("a broken key file replaced the serving certificate")
+	}
+
+	if logs.Len() == 0 {
+		t.Errorf("the failed reload was not logged")
+	}
+}
+
+func TestRunServesDevelopmentCertificate(t *testing.T) {
+	// asset
+	srv := New("tcp", 0, WithAddr("127.0.0.1:0"), WithTLS("", ""), WithLogger(log.New(io.Discard, "", 0)))
+	srv.RegisterEndpoint("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- srv.Run(ctx)
+	}()
+
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	addr := waitReady(t, srv, done)
+
+	// act
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+
+	if err != nil {
+		t.Fatalf("unexpected handshake error: %v", err)
+	}
+
+	devCert := conn.ConnectionState().PeerCertificates[0]
+	conn.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(devCert)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots},
+		ForceAttemptHTTP2: true,
+	}}
+
+	response, err := client.Get("https://" + addr + "/")
+
+	// assert
+	if err != nil {
+		t.Fatalf("development certificate does not verify for %v: %v", addr, err)
+	}
+
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+
+	if string(body) != "HTTP/2.0" {
+		t.Errorf("negotiated protocol: got=%v, want=%v", string(body), "HTTP/2.0")
+	}
+
+	_, err = tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS11})
+
+	if err == nil {
+		t.Errorf("a TLS 1.1 handshake succeeded")
+	}
+}
+
+func TestCertificateCheckedOncePerInterval(t *testing.T) {
+	// asset
+	dir := t.TempDir()
+	now := time.Now()
+	certFile, keyFile, first := writeKeyPair(t, dir, now)
+
+	reloader, err := newCertReloader(certFile, keyFile, time.Hour, log.New(io.Discard, "", 0))
+
+	if err != nil {
+		t.Fatalf("unexpected reloader error: %v", err)
+	}
+
+	// act
+	writeKeyPair(t, dir, now.Add(time.Minute))
+	got, _ := reloader.GetCertificate(&tls.ClientHelloInfo{})
+
+	// assert
+	leaf, _ := x509.ParseCertificate(got.Certificate[0])
+
+	if !leaf.Equal(first) {
+		t.Errorf("the files were read again before the interval passed")
+	}
+}
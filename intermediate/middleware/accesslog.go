@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat selects how AccessLogMiddleware writes its records.
+type AccessLogFormat int
+
+const (
+	FormatSlog     AccessLogFormat = iota // one slog record per request
+	FormatCommon                          // Common Log Format, as Apache and nginx write it
+	FormatCombined                        // Common Log Format plus referer and user agent
+)
+
+// AccessLogField is an attribute of the slog records of AccessLogMiddleware.
+type AccessLogField string
+
+const (
+	FieldMethod     AccessLogField = "method"
+	FieldPath       AccessLogField = "path"
+	FieldQuery      AccessLogField = "query"
+	FieldProto      AccessLogField = "proto"
+	FieldHost       AccessLogField = "host"
+	FieldStatus     AccessLogField = "status"
+	FieldBytes      AccessLogField = "bytes"
+	FieldDuration   AccessLogField = "duration"
+	FieldRemoteAddr AccessLogField = "remote_addr"
+	FieldUserAgent  AccessLogField = "user_agent"
+	FieldReferer    AccessLogField = "referer"
+	FieldRequestID  AccessLogField = "request_id" // set by RequestIDMiddleware
+)
+
+// DefaultAccessLogFields are logged when AccessLogConfig.Fields is empty.
+var DefaultAccessLogFields = []AccessLogField{
+	FieldMethod, FieldPath, FieldStatus, FieldBytes, FieldDuration, FieldRemoteAddr,
+}
+
+type AccessLogConfig struct {
+	Format AccessLogFormat
+
+	// Logger receives the records of FormatSlog; nil means slog.Default().
+	// Responses with a 5xx status are logged at error level, 4xx at warn
+	// level and everything else at info level.
+	Logger *slog.Logger
+
+	// Fields are the attributes of FormatSlog records, in this order. The
+	// Common and Combined formats always have the same fields.
+	Fields []AccessLogField
+
+	// Output receives the lines of FormatCommon and FormatCombined; nil
+	// means os.Stdout.
+	Output io.Writer
+
+	// ExcludePaths are not logged, e.g. "/healthz" for a noisy probe. An
+	// entry ending in a slash excludes everything below it, e.g. "/static/".
+	ExcludePaths []string
+
+	now func() time.Time // replaced in tests
+}
+
+// AccessLogMiddleware logs every request once its response is complete,
+// with the status code, the size of the body and how long it took.
+func AccessLogMiddleware(cfg AccessLogConfig) func(http.Handler) http.Handler {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	if len(cfg.Fields) == 0 {
+		cfg.Fields = DefaultAccessLogFields
+	}
+
+	if cfg.Output == nil {
+		cfg.Output = os.Stdout
+	}
+
+	if cfg.now == nil {
+		cfg.now = time.Now
+	}
+
+	// lines of concurrent requests must not interleave
+	var mu sync.Mutex
+
+	return func(handler http.Handler) http.Handler {
+		middleware := func(w http.ResponseWriter, r *http.Request) {
+			if excluded(cfg.ExcludePaths, r.URL.Path) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			start := cfg.now()
+			rec := &responseRecorder{ResponseWriter: w}
+
+			// log even when the handler panics, before the panic goes on
+			defer func() {
+				entry := accessLogEntry{r: r, rec: rec, start: start, duration: cfg.now().Sub(start)}
+
+				switch cfg.Format {
+				case FormatSlog:
+					cfg.Logger.LogAttrs(r.Context(), entry.level(), "request", entry.attrs(cfg.Fields)...)
+				default:
+					line := entry.commonLog(cfg.Format == FormatCombined)
+
+					mu.Lock()
+					io.WriteString(cfg.Output, line)
+					mu.Unlock()
+				}
+			}()
+
+			handler.ServeHTTP(rec, r)
+			rec.completed = true
+		}
+
+		return http.HandlerFunc(middleware)
+	}
+}
+
+func excluded(paths []string, path string) bool {
+	for _, p := range paths {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// responseRecorder remembers the status code and the number of body bytes
+// of a response. It passes flushing, hijacking and the other optional
+// methods through, directly or via Unwrap for http.ResponseController.
+type responseRecorder struct {
+	http.ResponseWriter
+
+	status      int
+	bytes       int64
+	wroteHeader bool
+	hijacked    bool
+	completed   bool // the handler returned instead of panicking
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if rec.wroteHeader {
+		rec.ResponseWriter.WriteHeader(code) // let net/http complain
+		return
+	}
+
+	// informational responses such as 103 Early Hints come before the
+	// real one
+	if code >= 200 || code == http.StatusSwitchingProtocols {
+		rec.status = code
+		rec.wroteHeader = true
+	}
+
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytes += int64(n)
+
+	return n, err
+}
+
+// ReadFrom keeps io.Copy able to use sendfile and the like.
+func (rec *responseRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+
+	n, err := io.Copy(rec.ResponseWriter, src)
+	rec.bytes += n
+
+	return n, err
+}
+
+func (rec *responseRecorder) Flush() {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+func (rec *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+
+	if err == nil {
+		rec.hijacked = true
+	}
+
+	return conn, rw, err
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// statusCode is the status the client got: net/http answers 200 for a
+// handler that returns without writing anything. 0 means there was no
+// response, because the handler panicked or hijacked the connection.
+func (rec *responseRecorder) statusCode() int {
+	if rec.status == 0 && rec.completed && !rec.hijacked {
+		return http.StatusOK
+	}
+
+	return rec.status
+}
+
+type accessLogEntry struct {
+	r        *http.Request
+	rec      *responseRecorder
+	start    time.Time
+	duration time.Duration
+}
+
+func (e accessLogEntry) level() slog.Level {
+	switch status := e.rec.statusCode(); {
+	case status >= 500 || !e.rec.completed:
+		return slog.LevelError
+	case status >= 400:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func (e accessLogEntry) attrs(fields []AccessLogField) []slog.Attr {
+	attrs := make([]slog.Attr, 0, len(fields)+1)
+
+	for _, field := range fields {
+		key := string(field)
+
+		switch field {
+		case FieldMethod:
+			attrs = append(attrs, slog.String(key, e.r.Method))
+		case FieldPath:
+			attrs = append(attrs, slog.String(key, e.r.URL.Path))
+		case FieldQuery:
+			attrs = append(attrs, slog.String(key, e.r.URL.RawQuery))
+		case FieldProto:
+			attrs = append(attrs, slog.String(key, e.r.Proto))
+		case FieldHost:
+			attrs = append(attrs, slog.String(key, e.r.Host))
+		case FieldStatus:
+			attrs = append(attrs, slog.Int(key, e.rec.statusCode()))
+		case FieldBytes:
+			attrs = append(attrs, slog.Int64(key, e.rec.bytes))
+		case FieldDuration:
+			attrs = append(attrs, slog.Duration(key, e.duration))
+		case FieldRemoteAddr:
+			attrs = append(attrs, slog.String(key, e.r.RemoteAddr))
+		case FieldUserAgent:
+			attrs = append(attrs, slog.String(key, e.r.UserAgent()))
+		case FieldReferer:
+			attrs = append(attrs, slog.String(key, e.r.Referer()))
+		case FieldRequestID:
+			attrs = append(attrs, slog.String(key, RequestID(e.r)))
+		}
+	}
+
+	if e.rec.hijacked {
+		attrs = append(attrs, slog.Bool("hijacked", true))
+	}
+
+	if !e.rec.completed {
+		attrs = append(attrs, slog.Bool("panicked", true))
+	}
+
+	return attrs
+}
+
+// commonLog formats the entry as a line of the Common Log Format,
+//
+//	127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326
+//
+// with the quoted referer and user agent added in the Combined format.
+func (e accessLogEntry) commonLog(combined bool) string {
+	host, _, err := net.SplitHostPort(e.r.RemoteAddr)
+
+	if err != nil {
+		host = e.r.RemoteAddr
+	}
+
+	user := "-"
+
+	if name, _, ok := e.r.BasicAuth(); ok && name != "" {
+		user = quoteLog(name)
+	}
+
+	status, size := "-", "-"
+
+	if code := e.rec.statusCode(); code != 0 {
+		status = strconv.Itoa(code)
+	}
+
+	if e.rec.bytes > 0 {
+		size = strconv.FormatInt(e.rec.bytes, 10)
+	}
+
+	requestLine := fmt.Sprintf("%v %v %v", e.r.Method, e.r.URL.RequestURI(), e.r.Proto)
+	line := fmt.Sprintf("%v - %v [%v] \"%v\" %v %v",
+		orDash(host), user, e.start.Format("02/Jan/2006:15:04:05 -0700"),
+		quoteLog(requestLine), status, size,
+	)
+
+	if combined {
+		line += fmt.Sprintf(" \"%v\" \"%v\"", quoteLog(orDash(e.r.Referer())), quoteLog(orDash(e.r.UserAgent())))
+	}
+
+	return line + "\n"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// quoteLog escapes what would break a log line out of s: quotes,
+// backslashes and control characters.
+func quoteLog(s string) string {
+	quoted := strconv.Quote(s)
+
+	return quoted[1 : len(quoted)-1]
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock returns start on the first call and start+step after that.
+func fakeClock(start time.Time, step time.Duration) func() time.Time {
+	calls := 0
+
+	return func() time.Time {
+		calls++
+
+		if calls == 1 {
+			return start
+		}
+
+		return start.Add(step)
+	}
+}
+
+func slogRecords(t *testing.T, logs *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var record map[string]any
+
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("log line is not JSON: %v (%q)", err, line)
+		}
+
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestAccessLogSlog(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handler http.HandlerFunc
+		fields  []AccessLogField
+		want    map[string]any
+	}{
+		{
+			name: "default fields",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "Kaman! Kachick!")
+			},
+			want: map[string]any{
+				"level": "INFO", "msg": "request", "method": "GET", "path": "/chow",
+				"status": 200.0, "bytes": 15.0, "duration": float64(3 * time.Millisecond), "remote_addr": "192.0.2.1:1234",
+			},
+		},
+		{
+			name: "chosen fields",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.NotFound(w, r)
+			},
+			fields: []AccessLogField{FieldStatus, FieldQuery, FieldUserAgent},
+			want:   map[string]any{"level": "WARN", "msg": "request", "status": 404.0, "query": "q=1", "user_agent": "crab/1.0"},
+		},
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			fields: []AccessLogField{FieldStatus, FieldBytes},
+			want:   map[string]any{"level": "ERROR", "msg": "request", "status": 502.0, "bytes": 0.0},
+		},
+		{
+			name: "early hints",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusEarlyHints)
+				w.WriteHeader(http.StatusCreated)
+			},
+			fields: []AccessLogField{FieldStatus},
+			want:   map[string]any{"level": "INFO", "msg": "request", "status": 201.0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if a.Key == slog.TimeKey {
+						return slog.Attr{}
+					}
+
+					return a
+				},
+			}))
+			cfg := AccessLogConfig{Logger: logger, Fields: tc.fields, now: fakeClock(time.Now(), 3*time.Millisecond)}
+			handler := AccessLogMiddleware(cfg)(tc.handler)
+
+			request := httptest.NewRequest(http.MethodGet, "/chow?q=1", nil)
+			request.Header.Set("User-Agent", "crab/1.0")
+
+			// act
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			// assert
+			records := slogRecords(t, &logs)
+
+			if len(records) != 1 {
+				t.Fatalf("records: got=%v, want 1", len(records))
+			}
+
+			if got := records[0]; fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAccessLogCommonFormats(t *testing.T) {
+	start := time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60))
+
+	testCases := []struct {
+		name   string
+		format AccessLogFormat
+		want   string
+	}{
+		{
+			name:   "common",
+			format: FormatCommon,
+			want:   `192.0.2.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /alan?up=there HTTP/1.1" 200 38` + "\n",
+		},
+		{
+			name:   "combined",
+			format: FormatCombined,
+			want:   `192.0.2.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /alan?up=there HTTP/1.1" 200 38 "-" "say \"hi\""` + "\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			var out bytes.Buffer
+			cfg := AccessLogConfig{Format: tc.format, Output: &out, now: fakeClock(start, time.Second)}
+			handler := AccessLogMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "Hey Alan, what are you doing up there!")
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/alan?up=there", nil)
+			request.SetBasicAuth("frank", "secret")
+			request.Header.Set("User-Agent", `say "hi"`)
+
+			// act
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			// assert
+			if got := out.String(); got != tc.want {
+				t.Errorf("got=%q, want=%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAccessLogExcludePaths(t *testing.T) {
+	// asset
+	var out bytes.Buffer
+	cfg := AccessLogConfig{Format: FormatCommon, Output: &out, ExcludePaths: []string{"/healthz", "/static/"}}
+	handler := AccessLogMiddleware(cfg)(http.NotFoundHandler())
+
+	// act
+	for _, target := range []string{"/healthz", "/static/crab.png", "/healthz/deep", "/chow"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	// assert
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+	if len(lines) != 2 || !strings.Contains(lines[0], "/healthz/deep") || !strings.Contains(lines[1], "/chow") {
+		t.Errorf("logged lines: got=%q, want /healthz/deep and /chow only", lines)
+	}
+}
+
+// lineWriter hands every write over a channel, for logs written by the
+// handlers of a running server.
+type lineWriter chan string
+
+func (lw lineWriter) Write(p []byte) (int, error) {
+	lw <- string(p)
+	return len(p), nil
+}
+
+func TestAccessLogKeepsOptionalInterfaces(t *testing.T) {
+	// asset
+	out := make(lineWriter, 2)
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/flush", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Errorf("the ResponseWriter is no http.Flusher")
+		}
+
+		rc := http.NewResponseController(w)
+
+		// only reachable through Unwrap
+		if err := rc.SetWriteDeadline(time.Now().Add(time.Minute)); err != nil {
+			t.Errorf("SetWriteDeadline: unexpected error: %v", err)
+		}
+
+		io.WriteString(w, "first ")
+		rc.Flush()
+		io.WriteString(w, "second")
+	})
+
+	mux.HandleFunc("/hijack", func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+
+		if err != nil {
+			t.Errorf("Hijack: unexpected error: %v", err)
+			return
+		}
+
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 6\r\nConnection: close\r\n\r\nraw ok")
+		rw.Flush()
+	})
+
+	testServer := httptest.NewServer(AccessLogMiddleware(AccessLogConfig{Format: FormatCommon, Output: out})(mux))
+	defer testServer.Close()
+
+	// act
+	response, err := http.Get(testServer.URL + "/flush")
+
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+
+	conn, err := net.Dial("tcp", testServer.Listener.Addr().String())
+
+	if err != nil {
+		t.Fatalf("unexpected dial error: %v", err)
+	}
+
+	defer conn.Close()
+	fmt.Fprint(conn, "GET /hijack HTTP/1.1\r\nHost: crab\r\n\r\n")
+	hijacked, err := http.ReadResponse(bufio.NewReader(conn), nil)
+
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+
+	raw, _ := io.ReadAll(hijacked.Body)
+
+	// assert
+	if string(body) != "first second" || string(raw) != "raw ok" {
+		t.Errorf("bodies: got=(%q, %q), want=(%q, %q)", body, raw, "first second", "raw ok")
+	}
+
+	flushLine, hijackLine := <-out, <-out
+
+	if !strings.HasSuffix(flushLine, `"GET /flush HTTP/1.1" 200 12`+"\n") || !strings.HasSuffix(hijackLine, `"GET /hijack HTTP/1.1" - -`+"\n") {
+		t.Errorf("logged lines: got=%q, %q", flushLine, hijackLine)
+	}
+}
+
+func TestAccessLogPanickingHandler(t *testing.T) {
+	// asset
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := AccessLogMiddleware(AccessLogConfig{Logger: logger})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("Mr. Chow")
+	}))
+
+	// act
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("the panic did not reach the caller")
+			}
+		}()
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/chow", nil))
+	}()
+
+	// assert
+	records := slogRecords(t, &logs)
+
+	if len(records) != 1 || records[0]["level"] != "ERROR" || records[0]["panicked"] != true || records[0]["status"] != 0.0 {
+		t.Errorf("got=%v, want an error record with status 0 and panicked", records)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrNoCredentials is returned by a Verifier for a request that carries none
+// of the credentials it checks, so that the next Verifier gets a go.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is who a request was authenticated as.
+type Principal struct {
+	Subject string         // the user name, the name of the API key or the JWT "sub"
+	Method  string         // "basic", "api_key" or "jwt"
+	Claims  map[string]any // of a JWT, nil otherwise
+}
+
+// Verifier checks one kind of credentials, e.g. a password or a token.
+type Verifier interface {
+	// Verify returns the principal the credentials of r belong to,
+	// ErrNoCredentials if r has none of the kind, or why they are invalid.
+	Verify(r *http.Request) (*Principal, error)
+
+	// Challenge is the WWW-Authenticate value that tells a client how to
+	// authenticate, err being what Verify returned, if anything.
+	Challenge(realm string, err error) string
+}
+
+type AuthConfig struct {
+	// Verifiers are tried in this order; the first to find its kind of
+	// credentials decides.
+	Verifiers []Verifier
+
+	// Realm names the protected space in the challenges; empty means
+	// "restricted".
+	Realm string
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the principal AuthMiddleware stored in ctx.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+
+	return p, ok
+}
+
+// AuthMiddleware lets requests through that one of cfg.Verifiers accepts,
+// with the principal in their context, see PrincipalFromContext. Everything
+// else gets 401 Unauthorized with a WWW-Authenticate challenge: of the
+// verifier that rejected the credentials, or of every verifier when there
+// were none.
+func AuthMiddleware(cfg AuthConfig) func(http.Handler) http.Handler {
+	if cfg.Realm == "" {
+		cfg.Realm = "restricted"
+	}
+
+	return func(handler http.Handler) http.Handler {
+		middleware := func(w http.ResponseWriter, r *http.Request) {
+			for _, v := range cfg.Verifiers {
+				principal, err := v.Verify(r)
+
+				if errors.Is(err, ErrNoCredentials) {
+					continue
+				}
+
+				if err != nil {
+					unauthorized(w, v.Challenge(cfg.Realm, err))
+					return
+				}
+
+				handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+				return
+			}
+
+			challenges := make([]string, len(cfg.Verifiers))
+
+			for i, v := range cfg.Verifiers {
+				challenges[i] = v.Challenge(cfg.Realm, nil)
+			}
+
+			unauthorized(w, challenges...)
+		}
+
+		return http.HandlerFunc(middleware)
+	}
+}
+
+func unauthorized(w http.ResponseWriter, challenges ...string) {
+	for _, challenge := range challenges {
+		w.Header().Add("WWW-Authenticate", challenge)
+	}
+
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// quoteParam quotes v as the value of a challenge parameter.
+func quoteParam(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
+
+// BasicVerifier checks HTTP Basic credentials against bcrypt hashes.
+type BasicVerifier struct {
+	hashes map[string][]byte // by user name
+
+	// dummy is compared for unknown users, so that they take as long as
+	// known ones and do not give away which names exist
+	dummyOnce sync.Once
+	dummy     []byte
+}
+
+// NewBasicVerifier checks passwords against hashes, bcrypt hashes by user
+// name.
+func NewBasicVerifier(hashes map[string][]byte) *BasicVerifier {
+	return &BasicVerifier{hashes: hashes}
+}
+
+// LoadPasswordFile reads bcrypt hashes by user name from a file in the format
+// of htpasswd -B: "name:hash" lines, with "#" starting a comment.
+func LoadPasswordFile(path string) (*BasicVerifier, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	hashes := map[string][]byte{}
+	scanner := bufio.NewScanner(f)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, hash, ok := strings.Cut(line, ":")
+
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%v:%v: want name:hash", path, n)
+		}
+
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%v:%v: %w", path, n, err)
+		}
+
+		hashes[name] = []byte(hash)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewBasicVerifier(hashes), nil
+}
+
+func (bv *BasicVerifier) Verify(r *http.Request) (*Principal, error) {
+	name, password, ok := r.BasicAuth()
+
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	hash, known := bv.hashes[name]
+
+	if !known {
+		hash = bv.dummyHash()
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !known {
+		return nil, errors.New("wrong user name or password")
+	}
+
+	return &Principal{Subject: name, Method: "basic"}, nil
+}
+
+func (bv *BasicVerifier) dummyHash() []byte {
+	bv.dummyOnce.Do(func() {
+		cost := bcrypt.DefaultCost
+
+		for _, hash := range bv.hashes {
+			cost, _ = bcrypt.Cost(hash)
+			break
+		}
+
+		bv.dummy, _ = bcrypt.GenerateFromPassword([]byte("crab"), cost)
+	})
+
+	return bv.dummy
+}
+
+func (bv *BasicVerifier) Challenge(realm string, err error) string {
+	return "Basic realm=" + quoteParam(realm) + `, charset="UTF-8"`
+}
+
+// APIKeyVerifier checks a static API key sent in a header.
+type APIKeyVerifier struct {
+	header string
+	names  map[[sha256.Size]byte]string // by the hash of the key
+}
+
+// NewAPIKeyVerifier accepts the keys in names, sent in header, e.g.
+// "X-API-Key". names maps every key to the name the principal gets.
+func NewAPIKeyVerifier(header string, names map[string]string) *APIKeyVerifier {
+	av := &APIKeyVerifier{header: header, names: map[[sha256.Size]byte]string{}}
+
+	// looking up hashes instead of the keys leaks nothing about the keys
+	// through the time the lookup takes
+	for key, name := range names {
+		av.names[sha256.Sum256([]byte(key))] = name
+	}
+
+	return av
+}
+
+func (av *APIKeyVerifier) Verify(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(av.header)
+
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	name, ok := av.names[sha256.Sum256([]byte(key))]
+
+	if !ok {
+		return nil, errors.New("unknown API key")
+	}
+
+	return &Principal{Subject: name, Method: "api_key"}, nil
+}
+
+func (av *APIKeyVerifier) Challenge(realm string, err error) string {
+	return "ApiKey realm=" + quoteParam(realm) + ", header=" + quoteParam(av.header)
+}
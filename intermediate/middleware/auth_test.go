@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func passwordFile(t *testing.T, lines ...string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	data := ""
+
+	for _, line := range lines {
+		data += line + "\n"
+	}
+
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+
+	return path
+}
+
+func bcryptHash(t *testing.T, password string) string {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+
+	if err != nil {
+		t.Fatalf("unexpected bcrypt error: %v", err)
+	}
+
+	return string(hash)
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	// asset
+	basic, err := LoadPasswordFile(passwordFile(t,
+		"# the wolfpack",
+		"alan:"+bcryptHash(t, "up there"),
+		"",
+		"stu:"+bcryptHash(t, "dentist"),
+	))
+
+	if err != nil {
+		t.Fatalf("unexpected error loading the password file: %v", err)
+	}
+
+	apiKeys := NewAPIKeyVerifier("X-API-Key", map[string]string{"kaman-kachick": "chow"})
+	jwt := NewJWTVerifier(JWTConfig{HMACSecrets: map[string][]byte{"": []byte("wolfpack")}})
+
+	var principal *Principal
+	handler := AuthMiddleware(AuthConfig{Verifiers: []Verifier{basic, apiKeys, jwt}, Realm: "hangover"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = PrincipalFromContext(r.Context())
+	}))
+
+	testCases := []struct {
+		name       string
+		setup      func(r *http.Request)
+		wantStatus int
+		want       Principal
+		challenges []string
+	}{
+		{
+			name:       "basic",
+			setup:      func(r *http.Request) { r.SetBasicAuth("alan", "up there") },
+			wantStatus: http.StatusOK,
+			want:       Principal{Subject: "alan", Method: "basic"},
+		},
+		{
+			name:       "API key",
+			setup:      func(r *http.Request) { r.Header.Set("X-API-Key", "kaman-kachick") },
+			wantStatus: http.StatusOK,
+			want:       Principal{Subject: "chow", Method: "api_key"},
+		},
+		{
+			name: "no credentials",
+			setup: func(r *http.Request) {
+				r.Header.Set("Authorization", "Digest username=\"alan\"")
+			},
+			wantStatus: http.StatusUnauthorized,
+			challenges: []string{
+				`Basic realm="hangover", charset="UTF-8"`,
+				`ApiKey realm="hangover", header="X-API-Key"`,
+				`Bearer realm="hangover"`,
+			},
+		},
+		{
+			name:       "wrong password",
+			setup:      func(r *http.Request) { r.SetBasicAuth("stu", "up there") },
+			wantStatus: http.StatusUnauthorized,
+			challenges: []string{`Basic realm="hangover", charset="UTF-8"`},
+		},
+		{
+			name:       "unknown user",
+			setup:      func(r *http.Request) { r.SetBasicAuth("doug", "up there") },
+			wantStatus: http.StatusUnauthorized,
+			challenges: []string{`Basic realm="hangover", charset="UTF-8"`},
+		},
+		{
+			name:       "unknown API key",
+			setup:      func(r *http.Request) { r.Header.Set("X-API-Key", "kaman") },
+			wantStatus: http.StatusUnauthorized,
+			challenges: []string{`ApiKey realm="hangover", header="X-API-Key"`},
+		},
+		{
+			name:       "invalid token",
+			setup:      func(r *http.Request) { r.Header.Set("Authorization", "Bearer kaman-kachick") },
+			wantStatus: http.StatusUnauthorized,
+			challenges: []string{`Bearer realm="hangover", error="invalid_token", error_description="malformed token"`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			principal = nil
+			request := httptest.NewRequest(http.MethodGet, "/alan", nil)
+			tc.setup(request)
+			rw := httptest.NewRecorder()
+
+			// act
+			handler.ServeHTTP(rw, request)
+
+			// assert
+			if rw.Code != tc.wantStatus {
+				t.Fatalf("status: got=%v, want=%v", rw.Code, tc.wantStatus)
+			}
+
+			if got := rw.Header().Values("WWW-Authenticate"); !slices.Equal(got, tc.challenges) {
+				t.Errorf("challenges: got=%q, want=%q", got, tc.challenges)
+			}
+
+			if tc.wantStatus == http.StatusOK && (principal == nil || principal.Subject != tc.want.Subject || principal.Method != tc.want.Method) {
+				t.Errorf("principal: got=%+v, want=%+v", principal, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadPasswordFileErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		line string
+	}{
+		{name: "no colon", line: "alan"},
+		{name: "no name", line: ":" + bcryptHash(t, "up there")},
+		{name: "not bcrypt", line: "alan:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g="},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// act
+			_, err := LoadPasswordFile(passwordFile(t, tc.line))
+
+			// assert
+			if err == nil {
+				t.Errorf("loading %q succeeded", tc.line)
+			}
+		})
+	}
+
+	if _, err := LoadPasswordFile(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: got=%v, want=%v", err, os.ErrNotExist)
+	}
+}
+
+func TestQuoteParam(t *testing.T) {
+	// act
+	got := quoteParam(`say "hi" \o/`)
+
+	// assert
+	if want := `"say \"hi\" \\o/"`; got != want {
+		t.Errorf("got=%v, want=%v", got, want)
+	}
+}
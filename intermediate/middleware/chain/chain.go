@@ -0,0 +1,60 @@
+// Package chain composes middleware, so that a stack of them reads as a list
+// instead of calls nested by hand.
+package chain
+
+import "net/http"
+
+// Middleware wraps a handler in some logic of its own, e.g. logging.
+type Middleware func(http.Handler) http.Handler
+
+// Chain is an immutable list of middleware. Appending to a chain returns a
+// new one and leaves the old one as it was, so a common base chain can be
+// extended differently for different routes.
+type Chain struct {
+	mws []Middleware
+}
+
+// New returns a chain of mws. The first of them runs first: it sees the
+// request before and the response after all the others,
+//
+//	New(a, b, c).Then(h) == a(b(c(h)))
+func New(mws ...Middleware) Chain {
+	return Chain{mws: append([]Middleware(nil), mws...)}
+}
+
+// Append returns a new chain of c followed by mws, which run after those of c.
+func (c Chain) Append(mws ...Middleware) Chain {
+	joined := make([]Middleware, 0, len(c.mws)+len(mws))
+	joined = append(joined, c.mws...)
+	joined = append(joined, mws...)
+
+	return Chain{mws: joined}
+}
+
+// Extend returns a new chain of c followed by the middleware of other.
+func (c Chain) Extend(other Chain) Chain {
+	return c.Append(other.mws...)
+}
+
+// Then wraps handler in the middleware of c and returns the result. A nil
+// handler means http.DefaultServeMux, as it does for http.Server.
+func (c Chain) Then(handler http.Handler) http.Handler {
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+
+	for i := len(c.mws) - 1; i >= 0; i-- {
+		handler = c.mws[i](handler)
+	}
+
+	return handler
+}
+
+// ThenFunc is Then for a handler function.
+func (c Chain) ThenFunc(fn http.HandlerFunc) http.Handler {
+	if fn == nil {
+		return c.Then(nil)
+	}
+
+	return c.Then(fn)
+}
@@ -0,0 +1,150 @@
+package chain
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// tracer records when it sees the request and when the response, in trace.
+func tracer(name string, trace *[]string) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*trace = append(*trace, name+" before")
+			handler.ServeHTTP(w, r)
+			*trace = append(*trace, name+" after")
+		})
+	}
+}
+
+func serve(handler http.Handler) string {
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/chow", nil))
+
+	return rw.Body.String()
+}
+
+func TestThenOrder(t *testing.T) {
+	// asset
+	var trace []string
+	c := New(tracer("a", &trace), tracer("b", &trace), tracer("c", &trace))
+
+	// act
+	serve(c.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace = append(trace, "handler")
+	}))
+
+	// assert
+	want := []string{"a before", "b before", "c before", "handler", "c after", "b after", "a after"}
+
+	if !slices.Equal(trace, want) {
+		t.Errorf("got=%v, want=%v", trace, want)
+	}
+}
+
+func TestAppendAndExtend(t *testing.T) {
+	testCases := []struct {
+		name  string
+		chain func(trace *[]string) Chain
+		want  []string
+	}{
+		{
+			name: "append",
+			chain: func(trace *[]string) Chain {
+				return New(tracer("a", trace)).Append(tracer("b", trace), tracer("c", trace))
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "extend",
+			chain: func(trace *[]string) Chain {
+				return New(tracer("a", trace)).Extend(New(tracer("b", trace), tracer("c", trace)))
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "empty",
+			chain: func(trace *[]string) Chain {
+				return New().Append().Extend(Chain{})
+			},
+			want: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			var trace []string
+			handler := tc.chain(&trace).ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "Kaman! Kachick!")
+			})
+
+			// act
+			body := serve(handler)
+
+			// assert
+			var before []string
+
+			for i := range len(trace) / 2 {
+				before = append(before, trace[i][:1])
+			}
+
+			if body != "Kaman! Kachick!" || !slices.Equal(before, tc.want) {
+				t.Errorf("got=(%q, %v), want=(%q, %v)", body, before, "Kaman! Kachick!", tc.want)
+			}
+		})
+	}
+}
+
+func TestAppendLeavesTheBaseAlone(t *testing.T) {
+	// asset
+	var trace []string
+	base := New(tracer("a", &trace), tracer("b", &trace)).Append(tracer("c", &trace))
+
+	// act: appending to a chain with room in its slice must not overwrite
+	// what the other chain appended there
+	withD := base.Append(tracer("d", &trace))
+	withE := base.Append(tracer("e", &trace))
+
+	serve(withD.Then(http.NotFoundHandler()))
+	dTrace := trace
+	trace = nil
+	serve(withE.Then(http.NotFoundHandler()))
+	eTrace := trace
+	trace = nil
+	serve(base.Then(http.NotFoundHandler()))
+
+	// assert
+	if dTrace[3] != "d before" || eTrace[3] != "e before" || len(trace) != 6 {
+		t.Errorf("got=%v, %v, %v, want a-b-c-d, a-b-c-e and a-b-c", dTrace, eTrace, trace)
+	}
+}
+
+func TestNewCopiesItsArguments(t *testing.T) {
+	// asset
+	var trace []string
+	mws := []Middleware{tracer("a", &trace)}
+	c := New(mws...)
+
+	// act
+	mws[0] = tracer("z", &trace)
+	serve(c.Then(http.NotFoundHandler()))
+
+	// assert
+	if trace[0] != "a before" {
+		t.Errorf("got=%v, want the middleware New was given", trace)
+	}
+}
+
+func TestThenNil(t *testing.T) {
+	// act
+	handler := New().Then(nil)
+	handlerFunc := New().ThenFunc(nil)
+
+	// assert
+	if handler != http.DefaultServeMux || handlerFunc != http.DefaultServeMux {
+		t.Errorf("got=(%v, %v), want http.DefaultServeMux", handler, handlerFunc)
+	}
+}
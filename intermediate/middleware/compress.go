@@ -0,0 +1,353 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressTypes are compressed when CompressConfig.ContentTypes is
+// empty: text and the usual text-based formats. Images, archives and the like
+// are compressed already.
+var DefaultCompressTypes = []string{
+	"text/*",
+	"application/json",
+	"application/x-ndjson",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+type CompressConfig struct {
+	// MinSize is the smallest body worth compressing, in bytes; zero means
+	// 1024. Smaller bodies are sent as they are, unless the handler flushes
+	// before it got that far.
+	MinSize int
+
+	// Level is the gzip and deflate compression level, from
+	// gzip.HuffmanOnly to gzip.BestCompression; zero means
+	// gzip.DefaultCompression. gzip.NoCompression cannot be chosen, as it
+	// would only make bodies larger: leave a type out of ContentTypes
+	// instead.
+	Level int
+
+	// ContentTypes are the media types to compress; empty means
+	// DefaultCompressTypes. An entry like "text/*" covers the whole type.
+	ContentTypes []string
+}
+
+// CompressMiddleware compresses responses with gzip or deflate, whichever
+// the Accept-Encoding header of the request ranks higher, gzip on a tie.
+//
+// It leaves alone responses that have a Content-Encoding already, that
+// answer a range request, that are of a type not in cfg.ContentTypes or
+// smaller than cfg.MinSize. A compressed response loses its Content-Length,
+// which no longer fits, and its ETag becomes weak, since the bytes differ from
+// the uncompressed representation. A HEAD request gets the headers a GET
+// would: its size is what the handler wrote or announced in Content-Length.
+func CompressMiddleware(cfg CompressConfig) func(http.Handler) http.Handler {
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = 1024
+	}
+
+	if cfg.Level == 0 {
+		cfg.Level = gzip.DefaultCompression
+	}
+
+	if cfg.Level < gzip.HuffmanOnly || cfg.Level > gzip.BestCompression {
+		panic("CompressMiddleware: Level must be between gzip.HuffmanOnly and gzip.BestCompression")
+	}
+
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = DefaultCompressTypes
+	}
+
+	// the writers keep large buffers, worth reusing across responses
+	pools := map[string]*sync.Pool{
+		"gzip": {New: func() any {
+			zw, _ := gzip.NewWriterLevel(io.Discard, cfg.Level)
+			return zw
+		}},
+		"deflate": {New: func() any {
+			zw, _ := zlib.NewWriterLevel(io.Discard, cfg.Level)
+			return zw
+		}},
+	}
+
+	return func(handler http.Handler) http.Handler {
+		middleware := func(w http.ResponseWriter, r *http.Request) {
+			// caches must not hand a compressed response to a client that
+			// cannot read it, or the other way round
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Values("Accept-Encoding"))
+
+			if encoding == "" {
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				cfg:            &cfg,
+				encoding:       encoding,
+				pool:           pools[encoding],
+				head:           r.Method == http.MethodHead,
+			}
+			defer cw.close()
+
+			handler.ServeHTTP(cw, r)
+		}
+
+		return http.HandlerFunc(middleware)
+	}
+}
+
+// resettableWriter is what gzip.Writer and zlib.Writer have in common.
+type resettableWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compressWriter holds the body back until it knows whether to compress it:
+// once MinSize bytes came, the handler flushed or returned. The header goes
+// out at that point too, as compression changes it.
+type compressWriter struct {
+	http.ResponseWriter
+
+	cfg      *CompressConfig
+	encoding string
+	pool     *sync.Pool
+	head     bool // only the header goes out
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	zw          resettableWriter // nil unless compressing
+	discard     bool             // compressing a HEAD response
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		cw.ResponseWriter.WriteHeader(code) // let net/http complain
+		return
+	}
+
+	if code < 200 && code != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	cw.status = code
+	cw.wroteHeader = true
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+
+		if len(cw.buf) < cw.cfg.MinSize && !cw.knownLarge() {
+			return len(p), nil
+		}
+
+		return len(p), cw.decide(cw.compressible())
+	}
+
+	if cw.discard {
+		return len(p), nil
+	}
+
+	if cw.zw != nil {
+		return cw.zw.Write(p)
+	}
+
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends what the handler wrote so far, compressed if the response is
+// compressible whatever its size: a handler that flushes streams, and more
+// will come.
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if !cw.decided {
+		cw.decide(cw.compressible())
+	}
+
+	if cw.zw != nil {
+		cw.zw.Flush()
+	}
+
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// knownLarge reports whether the handler announced a body of at least MinSize.
+func (cw *compressWriter) knownLarge() bool {
+	n, err := strconv.Atoi(cw.Header().Get("Content-Length"))
+
+	return err == nil && n >= cw.cfg.MinSize
+}
+
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+
+	switch {
+	case cw.status == http.StatusNoContent || cw.status == http.StatusNotModified:
+		return false
+	case cw.status == http.StatusPartialContent || header.Get("Content-Range") != "":
+		return false
+	case header.Get("Content-Encoding") != "":
+		return false
+	}
+
+	// net/http would sniff the type from the compressed bytes
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+
+	return err == nil && matchesType(cw.cfg.ContentTypes, mediaType)
+}
+
+// decide sends the header, compressing the body from now on if compress is
+// set, and what was held back of the body.
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	header := cw.Header()
+
+	if compress {
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges") // the ranges would be of the compressed bytes
+		header.Set("Content-Encoding", cw.encoding)
+
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		if cw.head {
+			// net/http would take the length of an uncompressed body
+			// written for HEAD for the Content-Length
+			cw.discard = true
+		} else {
+			cw.zw = cw.pool.Get().(resettableWriter)
+			cw.zw.Reset(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+
+	if len(buf) == 0 || cw.discard {
+		return nil
+	}
+
+	var err error
+
+	if cw.zw != nil {
+		_, err = cw.zw.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+
+	return err
+}
+
+// close sends what is still held back and ends the compressed stream, once
+// the handler returned. A body that stayed below MinSize goes out as it is.
+// A handler answering HEAD need not write the body, the Content-Length it
+// set tells the size.
+func (cw *compressWriter) close() {
+	if !cw.decided && cw.wroteHeader {
+		large := len(cw.buf) >= cw.cfg.MinSize || (cw.head && cw.knownLarge())
+		cw.decide(large && cw.compressible())
+	}
+
+	if cw.zw != nil {
+		cw.zw.Close()
+		cw.zw.Reset(io.Discard)
+		cw.pool.Put(cw.zw)
+		cw.zw = nil
+	}
+}
+
+func matchesType(types []string, mediaType string) bool {
+	for _, t := range types {
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1])) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// negotiateEncoding returns "gzip" or "deflate", whichever the Accept-Encoding
+// headers give the higher q-value, or "" if they accept neither.
+func negotiateEncoding(accept []string) string {
+	gzipQ, deflateQ, anyQ := -1.0, -1.0, -1.0
+
+	for _, value := range accept {
+		for _, item := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(item, ";")
+			q := 1.0
+
+			for _, param := range strings.Split(params, ";") {
+				name, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+
+				if strings.EqualFold(name, "q") {
+					var err error
+
+					if q, err = strconv.ParseFloat(v, 64); err != nil {
+						q = 0
+					}
+				}
+			}
+
+			switch strings.ToLower(strings.TrimSpace(coding)) {
+			case "gzip", "x-gzip":
+				gzipQ = q
+			case "deflate":
+				deflateQ = q
+			case "*":
+				anyQ = q
+			}
+		}
+	}
+
+	// "*" stands for the codings not listed
+	if gzipQ < 0 {
+		gzipQ = anyQ
+	}
+
+	if deflateQ < 0 {
+		deflateQ = anyQ
+	}
+
+	switch {
+	case gzipQ > 0 && gzipQ >= deflateQ:
+		return "gzip"
+	case deflateQ > 0:
+		return "deflate"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func decompress(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+
+	var r io.Reader
+	var err error
+
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return string(body)
+	}
+
+	if err != nil {
+		t.Fatalf("unexpected %v error: %v", encoding, err)
+	}
+
+	plain, err := io.ReadAll(r)
+
+	if err != nil {
+		t.Fatalf("unexpected %v error: %v", encoding, err)
+	}
+
+	return string(plain)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	testCases := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: ""},
+		{accept: "gzip", want: "gzip"},
+		{accept: "deflate, gzip", want: "gzip"},
+		{accept: "gzip;q=0.5, deflate", want: "deflate"},
+		{accept: "GZIP ; Q=0.2, deflate;q=0.1", want: "gzip"},
+		{accept: "br, identity", want: ""},
+		{accept: "*", want: "gzip"},
+		{accept: "gzip;q=0, *", want: "deflate"},
+		{accept: "gzip;q=0, deflate;q=0, *", want: ""},
+		{accept: "x-gzip", want: "gzip"},
+		{accept: "gzip;q=bad", want: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.accept, func(t *testing.T) {
+			// act
+			got := negotiateEncoding([]string{tc.accept})
+
+			// assert
+			if got != tc.want {
+				t.Errorf("got=%q, want=%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompressMiddleware(t *testing.T) {
+	large := strings.Repeat(`{"chow":"Kaman! Kachick!"}`, 100)
+
+	testCases := []struct {
+		name           string
+		acceptEncoding string
+		rangeHeader    string
+		handler        http.HandlerFunc
+		wantEncoding   string
+		wantHeader     http.Header // checked besides the encoding; "" means absent
+	}{
+		{
+			name:           "gzip",
+			acceptEncoding: "gzip, deflate",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Content-Length", "2600")
+				w.Header().Set("ETag", `"chow"`)
+				io.WriteString(w, large)
+			},
+			wantEncoding: "gzip",
+			wantHeader:   http.Header{"Content-Length": {""}, "Etag": {`W/"chow"`}, "Vary": {"Accept-Encoding"}},
+		},
+		{
+			name:           "deflate in several writes",
+			acceptEncoding: "gzip;q=0.5, deflate",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.Header().Set("ETag", `W/"chow"`)
+
+				for i := 0; i < len(large); i += 100 {
+					io.WriteString(w, large[i:i+100])
+				}
+			},
+			wantEncoding: "deflate",
+			wantHeader:   http.Header{"Etag": {`W/"chow"`}},
+		},
+		{
+			name:           "sniffed type",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "<html><body>"+large+"</body></html>")
+			},
+			wantEncoding: "gzip",
+			wantHeader:   http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		},
+		{
+			name:           "small body",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Content-Length", "26")
+				io.WriteString(w, large[:26])
+			},
+			wantHeader: http.Header{"Content-Length": {"26"}, "Vary": {"Accept-Encoding"}},
+		},
+		{
+			name:           "no accepted encoding",
+			acceptEncoding: "br",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, large)
+			},
+			wantHeader: http.Header{"Vary": {"Accept-Encoding"}},
+		},
+		{
+			name:           "ineligible type",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				io.WriteString(w, large)
+			},
+		},
+		{
+			name:           "already compressed",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Encoding", "br")
+				io.WriteString(w, large)
+			},
+			wantEncoding: "br",
+		},
+		{
+			name:           "range",
+			acceptEncoding: "gzip",
+			rangeHeader:    "bytes=0-999",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader(large))
+			},
+			wantHeader: http.Header{"Content-Range": {"bytes 0-999/2600"}, "Content-Length": {"1000"}},
+		},
+		{
+			name:           "not modified",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotModified)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			handler := CompressMiddleware(CompressConfig{})(tc.handler)
+			request := httptest.NewRequest(http.MethodGet, "/chow", nil)
+			request.Header.Set("Accept-Encoding", tc.acceptEncoding)
+
+			if tc.rangeHeader != "" {
+				request.Header.Set("Range", tc.rangeHeader)
+			}
+
+			rw := httptest.NewRecorder()
+
+			// act
+			handler.ServeHTTP(rw, request)
+
+			// assert
+			if got := rw.Header().Get("Content-Encoding"); got != tc.wantEncoding {
+				t.Fatalf("Content-Encoding: got=%q, want=%q", got, tc.wantEncoding)
+			}
+
+			for key := range tc.wantHeader {
+				if got, want := rw.Header().Get(key), tc.wantHeader.Get(key); got != want {
+					t.Errorf("%v: got=%q, want=%q", key, got, want)
+				}
+			}
+
+			body := decompress(t, tc.wantEncoding, rw.Body.Bytes())
+			want := httptest.NewRecorder()
+			tc.handler(want, request)
+
+			if body != want.Body.String() {
+				t.Errorf("body: got=%q, want=%q", body, want.Body.String())
+			}
+		})
+	}
+}
+
+func TestCompressFileServer(t *testing.T) {
+	// asset
+	page := "<html><body>" + strings.Repeat("<p>Hey Alan, what are you doing up there!</p>", 50) + "</body></html>"
+	files := fstest.MapFS{"hangover.html": {Data: []byte(page), ModTime: time.Unix(1754537406, 0)}}
+	testServer := httptest.NewServer(CompressMiddleware(CompressConfig{})(http.FileServer(http.FS(files))))
+	defer testServer.Close()
+
+	// act: the client asks for gzip and unpacks it on its own
+	response, err := http.Get(testServer.URL + "/hangover.html")
+
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+
+	// assert
+	if !response.Uncompressed || string(body) != page {
+		t.Errorf("got=(uncompressed %v, %v bytes), want=(true, %v bytes)", response.Uncompressed, len(body), len(page))
+	}
+}
+
+func TestCompressHead(t *testing.T) {
+	body := strings.Repeat("Kaman! Kachick! ", 100)
+
+	testCases := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			name: "body written",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("ETag", `"chow"`)
+				io.WriteString(w, body)
+			},
+		},
+		{
+			name: "only Content-Length",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("ETag", `"chow"`)
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				w.WriteHeader(http.StatusOK)
+
+				if r.Method != http.MethodHead {
+					io.WriteString(w, body)
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			testServer := httptest.NewServer(CompressMiddleware(CompressConfig{})(tc.handler))
+			defer testServer.Close()
+
+			client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+			responses := make(map[string]*http.Response)
+
+			// act
+			for _, method := range []string{http.MethodGet, http.MethodHead} {
+				request, _ := http.NewRequest(method, testServer.URL, nil)
+				request.Header.Set("Accept-Encoding", "gzip")
+				response, err := client.Do(request)
+
+				if err != nil {
+					t.Fatalf("unexpected client error: %v", err)
+				}
+
+				io.Copy(io.Discard, response.Body)
+				response.Body.Close()
+				responses[method] = response
+			}
+
+			// assert
+			get, head := responses[http.MethodGet], responses[http.MethodHead]
+
+			for _, key := range []string{"Content-Encoding", "Etag", "Vary"} {
+				if got, want := head.Header.Get(key), get.Header.Get(key); got != want {
+					t.Errorf("%v: got=%q, want=%q as for GET", key, got, want)
+				}
+			}
+
+			if head.Header.Get("Content-Encoding") != "gzip" || head.ContentLength != -1 {
+				t.Errorf("got=(%q, Content-Length %v), want=(%q, none)", head.Header.Get("Content-Encoding"), head.ContentLength, "gzip")
+			}
+		})
+	}
+}
+
+func TestCompressFlushStreams(t *testing.T) {
+	// asset
+	release := make(chan struct{})
+	testServer := httptest.NewServer(CompressMiddleware(CompressConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "first\n")
+		w.(http.Flusher).Flush()
+
+		<-release
+		io.WriteString(w, "second\n")
+	})))
+	defer testServer.Close()
+	defer close(release)
+
+	response, err := http.Get(testServer.URL)
+
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+
+	defer response.Body.Close()
+
+	// act: the handler waits until the first line is through
+	first, err := bufio.NewReader(response.Body).ReadString('\n')
+
+	// assert
+	if !response.Uncompressed || first != "first\n" || err != nil {
+		t.Errorf("got=(uncompressed %v, %q, %v), want=(true, %q, nil)", response.Uncompressed, first, err, "first\n")
+	}
+}
+
+func TestCompressLevel(t *testing.T) {
+	testCases := []struct {
+		name   string
+		level  int
+		panics bool
+	}{
+		{name: "default", level: 0},
+		{name: "huffman only", level: gzip.HuffmanOnly},
+		{name: "best speed", level: gzip.BestSpeed},
+		{name: "best compression", level: gzip.BestCompression},
+		{name: "too high", level: 11, panics: true},
+		{name: "too low", level: -3, panics: true},
+	}
+
+	body := strings.Repeat("Kaman! Kachick! ", 100)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// assert
+			defer func() {
+				if p := recover(); (p != nil) != tc.panics {
+					t.Errorf("panicked: got=%v, want=%v", p, tc.panics)
+				}
+			}()
+
+			// act
+			handler := CompressMiddleware(CompressConfig{Level: tc.level})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				io.WriteString(w, body)
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/chow", nil)
+			request.Header.Set("Accept-Encoding", "gzip, deflate;q=0.5")
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, request)
+
+			encoding := rw.Header().Get("Content-Encoding")
+
+			if got := decompress(t, encoding, rw.Body.Bytes()); encoding != "gzip" || got != body {
+				t.Errorf("got=(%q, %v bytes), want=(%q, %v bytes)", encoding, len(got), "gzip", len(body))
+			}
+		})
+	}
+}
@@ -1,3 +1,5 @@
 module middleware
 
 go 1.24.4
+
+require golang.org/x/crypto v0.37.0
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+type JWTConfig struct {
+	// HMACSecrets verify HS256 tokens, by the "kid" of the token header;
+	// the secret under "" verifies tokens without a "kid".
+	HMACSecrets map[string][]byte
+
+	// RSAKeys verify RS256 tokens, by "kid", e.g. from LoadJWKS. A token
+	// without a "kid" is verified with the only key, if there is one.
+	RSAKeys map[string]*rsa.PublicKey
+
+	// Audience, if set, has to be in the "aud" claim; Issuer, if set, has
+	// to be the "iss" claim.
+	Audience string
+	Issuer   string
+
+	// Leeway allows for clocks that are a little off when checking "exp"
+	// and "nbf".
+	Leeway time.Duration
+
+	now func() time.Time // replaced in tests
+}
+
+// JWTVerifier checks JSON Web Tokens sent as bearer tokens (RFC 6750), signed
+// with HS256 or RS256. A token has to have an "exp" claim.
+type JWTVerifier struct {
+	cfg JWTConfig
+}
+
+func NewJWTVerifier(cfg JWTConfig) *JWTVerifier {
+	if cfg.now == nil {
+		cfg.now = time.Now
+	}
+
+	return &JWTVerifier{cfg: cfg}
+}
+
+func (jv *JWTVerifier) Verify(r *http.Request) (*Principal, error) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := jv.parse(strings.TrimSpace(token))
+
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+
+	return &Principal{Subject: subject, Method: "jwt", Claims: claims}, nil
+}
+
+func (jv *JWTVerifier) Challenge(realm string, err error) string {
+	challenge := "Bearer realm=" + quoteParam(realm)
+
+	if err != nil {
+		challenge += `, error="invalid_token", error_description=` + quoteParam(err.Error())
+	}
+
+	return challenge
+}
+
+// parse checks the signature and the claims of token and returns the claims.
+func (jv *JWTVerifier) parse(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+
+	if err := jv.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+
+	if err := jv.validate(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// verifySignature checks signature with a key of the kind alg asks for, so
+// that e.g. the public RSA key can never serve as an HMAC secret.
+func (jv *JWTVerifier) verifySignature(alg, kid, signed string, signature []byte) error {
+	switch alg {
+	case "HS256":
+		secret, ok := jv.cfg.HMACSecrets[kid]
+
+		if !ok {
+			return fmt.Errorf("unknown key %q", kid)
+		}
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("invalid signature")
+		}
+	case "RS256":
+		key, ok := jv.cfg.RSAKeys[kid]
+
+		if !ok && kid == "" && len(jv.cfg.RSAKeys) == 1 {
+			for _, only := range jv.cfg.RSAKeys {
+				key, ok = only, true
+			}
+		}
+
+		if !ok {
+			return fmt.Errorf("unknown key %q", kid)
+		}
+
+		digest := sha256.Sum256([]byte(signed))
+
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	return nil
+}
+
+func (jv *JWTVerifier) validate(claims map[string]any) error {
+	now := jv.cfg.now()
+
+	exp, ok, err := numericDate(claims, "exp")
+
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return errors.New("the token does not expire")
+	case !now.Before(exp.Add(jv.cfg.Leeway)):
+		return errors.New("the token expired")
+	}
+
+	nbf, ok, err := numericDate(claims, "nbf")
+
+	switch {
+	case err != nil:
+		return err
+	case ok && now.Before(nbf.Add(-jv.cfg.Leeway)):
+		return errors.New("the token is not valid yet")
+	}
+
+	if jv.cfg.Issuer != "" && claims["iss"] != jv.cfg.Issuer {
+		return errors.New("wrong issuer")
+	}
+
+	if jv.cfg.Audience != "" && !hasAudience(claims["aud"], jv.cfg.Audience) {
+		return errors.New("wrong audience")
+	}
+
+	return nil
+}
+
+// numericDate reads the claim name, seconds since the epoch.
+func numericDate(claims map[string]any, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	n, isNumber := v.(json.Number)
+	seconds, err := n.Float64()
+
+	// no date is that far off, and int64 would overflow for larger numbers
+	if !isNumber || err != nil || math.Abs(seconds) > 1e12 {
+		return time.Time{}, false, fmt.Errorf("malformed %q claim", name)
+	}
+
+	whole, frac := math.Modf(seconds)
+
+	return time.Unix(int64(whole), int64(frac*1e9)), true, nil
+}
+
+// hasAudience reports whether the "aud" claim, a string or an array of them,
+// contains audience.
+func hasAudience(aud any, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		return slices.Contains(aud, any(audience))
+	default:
+		return false
+	}
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	return decoder.Decode(v)
+}
+
+// LoadJWKS reads the RSA signing keys of a JSON Web Key Set file (RFC 7517)
+// by "kid", for JWTConfig.RSAKeys. Keys of other types are skipped.
+func LoadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := ParseJWKS(data)
+
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	return keys, nil
+}
+
+// ParseJWKS is LoadJWKS for a key set at hand.
+func ParseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") || (jwk.Alg != "" && jwk.Alg != "RS256") {
+			continue
+		}
+
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("key %q: malformed modulus or exponent", jwk.Kid)
+		}
+
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("key %q: %v bits are too few, want at least 2048", jwk.Kid, key.N.BitLen())
+		}
+
+		keys[jwk.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no RSA signing keys")
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var jwtNow = time.Unix(1754537406, 0)
+
+func segment(t *testing.T, v any) string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		t.Fatalf("unexpected marshal error: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256(t *testing.T, header, claims map[string]any, secret string) string {
+	t.Helper()
+
+	signed := segment(t, header) + "." + segment(t, claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, header, claims map[string]any, key *rsa.PrivateKey) string {
+	t.Helper()
+
+	signed := segment(t, header) + "." + segment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+
+	if err != nil {
+		t.Fatalf("unexpected signing error: %v", err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func jwksFile(t *testing.T, kid string, key *rsa.PublicKey) string {
+	t.Helper()
+
+	set := map[string]any{"keys": []map[string]string{
+		{"kty": "EC", "kid": "elliptic", "crv": "P-256", "x": "", "y": ""},
+		{
+			"kty": "RSA", "kid": kid, "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		},
+	}}
+
+	data, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+
+	return path
+}
+
+func verifyToken(jv *JWTVerifier, token string) (*Principal, error) {
+	request := httptest.NewRequest(http.MethodGet, "/alan", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	return jv.Verify(request)
+}
+
+func TestJWTClaims(t *testing.T) {
+	exp := jwtNow.Add(time.Minute).Unix()
+
+	testCases := []struct {
+		name    string
+		claims  map[string]any
+		wantErr string
+	}{
+		{name: "valid", claims: map[string]any{"sub": "alan", "exp": exp, "aud": "crab", "iss": "wolfpack"}},
+		{name: "audience in a list", claims: map[string]any{"sub": "alan", "exp": exp, "aud": []string{"chow", "crab"}, "iss": "wolfpack"}},
+		{name: "within the leeway", claims: map[string]any{"exp": jwtNow.Add(-time.Second).Unix(), "aud": "crab", "iss": "wolfpack"}},
+		{name: "fractional expiry", claims: map[string]any{"exp": float64(jwtNow.Unix()) + 0.5, "aud": "crab", "iss": "wolfpack"}},
+		{name: "no expiry", claims: map[string]any{"aud": "crab", "iss": "wolfpack"}, wantErr: "the token does not expire"},
+		{name: "expired", claims: map[string]any{"exp": jwtNow.Add(-time.Minute).Unix(), "aud": "crab", "iss": "wolfpack"}, wantErr: "the token expired"},
+		{
+			name:    "not valid yet",
+			claims:  map[string]any{"exp": exp, "nbf": jwtNow.Add(time.Minute).Unix(), "aud": "crab", "iss": "wolfpack"},
+			wantErr: "the token is not valid yet",
+		},
+		{name: "malformed expiry", claims: map[string]any{"exp": "tomorrow", "aud": "crab", "iss": "wolfpack"}, wantErr: `malformed "exp" claim`},
+		{name: "wrong audience", claims: map[string]any{"exp": exp, "aud": []string{"chow"}, "iss": "wolfpack"}, wantErr: "wrong audience"},
+		{name: "no audience", claims: map[string]any{"exp": exp, "iss": "wolfpack"}, wantErr: "wrong audience"},
+		{name: "wrong issuer", claims: map[string]any{"exp": exp, "aud": "crab", "iss": "chow"}, wantErr: "wrong issuer"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			jv := NewJWTVerifier(JWTConfig{
+				HMACSecrets: map[string][]byte{"": []byte("wolfpack")},
+				Audience:    "crab",
+				Issuer:      "wolfpack",
+				Leeway:      5 * time.Second,
+				now:         func() time.Time { return jwtNow },
+			})
+			token := signHS256(t, map[string]any{"alg": "HS256", "typ": "JWT"}, tc.claims, "wolfpack")
+
+			// act
+			principal, err := verifyToken(jv, token)
+
+			// assert
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Errorf("got=%v, want=%v", err, tc.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if sub, _ := tc.claims["sub"].(string); principal.Method != "jwt" || principal.Subject != sub || principal.Claims["iss"] != "wolfpack" {
+				t.Errorf("principal: got=%+v", principal)
+			}
+		})
+	}
+}
+
+func TestJWTSignatures(t *testing.T) {
+	// asset
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatalf("unexpected key error: %v", err)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatalf("unexpected key error: %v", err)
+	}
+
+	rsaKeys, err := LoadJWKS(jwksFile(t, "2025", &rsaKey.PublicKey))
+
+	if err != nil {
+		t.Fatalf("unexpected error loading the key set: %v", err)
+	}
+
+	jv := NewJWTVerifier(JWTConfig{
+		HMACSecrets: map[string][]byte{"hs": []byte("wolfpack")},
+		RSAKeys:     rsaKeys,
+		now:         func() time.Time { return jwtNow },
+	})
+
+	claims := map[string]any{"sub": "alan", "exp": jwtNow.Add(time.Minute).Unix()}
+	rs256 := signRS256(t, map[string]any{"alg": "RS256", "kid": "2025"}, claims, rsaKey)
+	parts := strings.Split(rs256, ".")
+
+	// the public key as an HMAC secret, the classic algorithm confusion
+	publicKey := rsaKey.PublicKey.N.Bytes()
+
+	testCases := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{name: "HS256", token: signHS256(t, map[string]any{"alg": "HS256", "kid": "hs"}, claims, "wolfpack")},
+		{name: "RS256", token: rs256},
+		{name: "RS256 without kid", token: signRS256(t, map[string]any{"alg": "RS256"}, claims, rsaKey)},
+		{name: "wrong secret", token: signHS256(t, map[string]any{"alg": "HS256", "kid": "hs"}, claims, "chow"), wantErr: "invalid signature"},
+		{name: "wrong RSA key", token: signRS256(t, map[string]any{"alg": "RS256", "kid": "2025"}, claims, otherKey), wantErr: "invalid signature"},
+		{name: "unknown kid", token: signHS256(t, map[string]any{"alg": "HS256", "kid": "2025"}, claims, "wolfpack"), wantErr: `unknown key "2025"`},
+		{
+			name:    "RSA key as HMAC secret",
+			token:   signHS256(t, map[string]any{"alg": "HS256", "kid": "2025"}, claims, string(publicKey)),
+			wantErr: `unknown key "2025"`,
+		},
+		{name: "no algorithm", token: segment(t, map[string]any{"alg": "none"}) + "." + parts[1] + ".", wantErr: `unsupported algorithm "none"`},
+		{
+			name:    "tampered claims",
+			token:   parts[0] + "." + segment(t, map[string]any{"sub": "chow", "exp": jwtNow.Add(time.Hour).Unix()}) + "." + parts[2],
+			wantErr: "invalid signature",
+		},
+		{name: "two parts", token: parts[0] + "." + parts[1], wantErr: "malformed token"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// act
+			principal, err := verifyToken(jv, tc.token)
+
+			// assert
+			if tc.wantErr == "" && (err != nil || principal.Subject != "alan") {
+				t.Errorf("got=(%+v, %v), want alan", principal, err)
+			}
+
+			if tc.wantErr != "" && (err == nil || err.Error() != tc.wantErr) {
+				t.Errorf("got=%v, want=%v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTNoBearerToken(t *testing.T) {
+	testCases := []string{"", "Basic YWxhbjp1cA==", "Bearer", "Bearer "}
+
+	for _, authorization := range testCases {
+		t.Run(authorization, func(t *testing.T) {
+			// asset
+			request := httptest.NewRequest(http.MethodGet, "/alan", nil)
+			request.Header.Set("Authorization", authorization)
+
+			// act
+			_, err := NewJWTVerifier(JWTConfig{}).Verify(request)
+
+			// assert
+			if err != ErrNoCredentials {
+				t.Errorf("got=%v, want=%v", err, ErrNoCredentials)
+			}
+		})
+	}
+}
+
+func TestParseJWKSErrors(t *testing.T) {
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+
+	if err != nil {
+		t.Fatalf("unexpected key error: %v", err)
+	}
+
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{name: "not JSON", data: "keys"},
+		{name: "no RSA keys", data: `{"keys":[{"kty":"EC","kid":"elliptic"}]}`},
+		{name: "encryption key only", data: `{"keys":[{"kty":"RSA","kid":"enc","use":"enc","n":"AQAB","e":"AQAB"}]}`},
+		{name: "malformed modulus", data: `{"keys":[{"kty":"RSA","kid":"bad","n":"!!","e":"AQAB"}]}`},
+		{
+			name: "too small",
+			data: fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"small","n":%q,"e":"AQAB"}]}`, base64.RawURLEncoding.EncodeToString(smallKey.N.Bytes())),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// act
+			keys, err := ParseJWKS([]byte(tc.data))
+
+			// assert
+			if err == nil {
+				t.Errorf("got=%v, want an error", keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Priority decides which requests LoadShedMiddleware sheds first.
+type Priority int
+
+const (
+	PriorityLow      Priority = iota // shed first, e.g. batch jobs and crawlers
+	PriorityNormal                   // everything else
+	PriorityHigh                     // served before the others, e.g. paying users
+	PriorityCritical                 // never limited nor shed, e.g. health checks
+)
+
+// DefaultPriority makes the usual health check paths critical, so that a
+// loaded server is not taken for a dead one, and everything else normal.
+func DefaultPriority(r *http.Request) Priority {
+	switch r.URL.Path {
+	case "/healthz", "/livez", "/readyz":
+		return PriorityCritical
+	default:
+		return PriorityNormal
+	}
+}
+
+type LoadShedConfig struct {
+	// InitialLimit is how many requests may run at once to begin with;
+	// zero means 10. The limit then moves between MinLimit and MaxLimit,
+	// zero meaning 1 and 1000.
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+
+	// TargetLatency is how long a request may take before the limit goes
+	// down; zero means 100ms.
+	TargetLatency time.Duration
+
+	// Backoff is what the limit is multiplied with for a request slower
+	// than TargetLatency, at most once per round of requests; zero means
+	// 0.9.
+	Backoff float64
+
+	// QueueSize is how many requests may wait for one to finish; zero means
+	// InitialLimit. MaxWait is how long they wait at most; zero means a
+	// second.
+	QueueSize int
+	MaxWait   time.Duration
+
+	// Priority classifies requests; nil means DefaultPriority.
+	Priority func(*http.Request) Priority
+
+	// RetryAfter is sent with shed requests; zero means a second.
+	RetryAfter time.Duration
+
+	now func() time.Time // replaced in tests
+}
+
+// LoadShedMiddleware caps the requests in flight, so that a spike in traffic
+// makes some clients wait or retry instead of making every request slow.
+//
+// The cap adapts with AIMD, the way TCP finds its congestion window: it goes
+// up by one after a round of as many requests as the cap that took up to
+// cfg.TargetLatency while the cap was in use, and a slower request multiplies
+// it with cfg.Backoff. One spike tends to slow a whole round of requests, so
+// the cap backs off once for it and ignores the rest of that round.
+// Requests over the cap wait in a queue, higher priorities first; when the
+// queue is full, or a request has waited cfg.MaxWait, it is shed with 503
+// Service Unavailable and a Retry-After header. A full queue makes room for a
+// request of higher priority by shedding the lowest one waiting.
+func LoadShedMiddleware(cfg LoadShedConfig) func(http.Handler) http.Handler {
+	return loadShedding(newAdaptiveLimiter(cfg))
+}
+
+func loadShedding(al *adaptiveLimiter) func(http.Handler) http.Handler {
+	cfg := al.cfg
+
+	return func(handler http.Handler) http.Handler {
+		middleware := func(w http.ResponseWriter, r *http.Request) {
+			priority := cfg.Priority(r)
+
+			if priority >= PriorityCritical {
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			if !al.acquire(r.Context(), priority) {
+				w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(cfg.RetryAfter), 1)))
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+
+				return
+			}
+
+			start := cfg.now()
+
+			defer func() {
+				al.release(cfg.now().Sub(start))
+			}()
+
+			handler.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(middleware)
+	}
+}
+
+// adaptiveLimiter hands out slots to run a request in, as many as its
+// limit, and queues the requests that find none free.
+type adaptiveLimiter struct {
+	cfg LoadShedConfig
+
+	mu         sync.Mutex
+	limit      float64
+	fast       int // requests within the target latency since the limit changed
+	ignoreSlow int // requests left of the round that last backed off
+	inFlight   int
+	queue      []*waiter // by priority, then by arrival
+}
+
+type waiter struct {
+	priority Priority
+	result   chan bool // true for a slot, false for shed; written once
+}
+
+func newAdaptiveLimiter(cfg LoadShedConfig) *adaptiveLimiter {
+	if cfg.InitialLimit <= 0 {
+		cfg.InitialLimit = 10
+	}
+
+	if cfg.MinLimit <= 0 {
+		cfg.MinLimit = 1
+	}
+
+	if cfg.MaxLimit <= 0 {
+		cfg.MaxLimit = 1000
+	}
+
+	if cfg.TargetLatency <= 0 {
+		cfg.TargetLatency = 100 * time.Millisecond
+	}
+
+	if cfg.Backoff <= 0 || cfg.Backoff >= 1 {
+		cfg.Backoff = 0.9
+	}
+
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = cfg.InitialLimit
+	}
+
+	if cfg.MaxWait <= 0 {
+		cfg.MaxWait = time.Second
+	}
+
+	if cfg.Priority == nil {
+		cfg.Priority = DefaultPriority
+	}
+
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = time.Second
+	}
+
+	if cfg.now == nil {
+		cfg.now = time.Now
+	}
+
+	limit := min(max(cfg.InitialLimit, cfg.MinLimit), cfg.MaxLimit)
+
+	return &adaptiveLimiter{cfg: cfg, limit: float64(limit)}
+}
+
+// capacity is the limit in whole requests.
+func (al *adaptiveLimiter) capacity() int {
+	return int(al.limit)
+}
+
+// acquire takes a slot, waiting for one if need be, and reports whether it
+// got one.
+func (al *adaptiveLimiter) acquire(ctx context.Context, priority Priority) bool {
+	al.mu.Lock()
+
+	if al.inFlight < al.capacity() && len(al.queue) == 0 {
+		al.inFlight++
+		al.mu.Unlock()
+
+		return true
+	}
+
+	w := &waiter{priority: priority, result: make(chan bool, 1)}
+
+	if len(al.queue) >= al.cfg.QueueSize {
+		lowest := al.queue[len(al.queue)-1]
+
+		if lowest.priority >= priority {
+			al.mu.Unlock()
+			return false
+		}
+
+		al.queue = al.queue[:len(al.queue)-1]
+		lowest.result <- false
+	}
+
+	// behind everyone of the same or a higher priority
+	i := slices.IndexFunc(al.queue, func(other *waiter) bool {
+		return other.priority < priority
+	})
+
+	if i < 0 {
+		i = len(al.queue)
+	}
+
+	al.queue = slices.Insert(al.queue, i, w)
+	al.mu.Unlock()
+
+	timer := time.NewTimer(al.cfg.MaxWait)
+	defer timer.Stop()
+
+	select {
+	case ok := <-w.result:
+		return ok
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if i := slices.Index(al.queue, w); i >= 0 {
+		al.queue = slices.Delete(al.queue, i, i+1)
+		return false
+	}
+
+	// the slot, or the verdict, came while giving up
+	return <-w.result
+}
+
+// release gives back a slot, after a request that took latency, and adapts
+// the limit to it.
+func (al *adaptiveLimiter) release(latency time.Duration) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	// the other requests of the round that backed off were likely slow for
+	// the same reason; only those after them tell about the new limit
+	ignored := al.ignoreSlow > 0
+
+	if ignored {
+		al.ignoreSlow--
+	}
+
+	if latency > al.cfg.TargetLatency {
+		al.fast = 0
+
+		if !ignored {
+			al.ignoreSlow = al.capacity() - 1
+			al.limit = max(al.limit*al.cfg.Backoff, float64(al.cfg.MinLimit))
+		}
+	} else if 2*al.inFlight >= al.capacity() {
+		// only a limit that is in use has proven it can go higher
+		al.fast++
+
+		if al.fast >= al.capacity() {
+			al.limit = min(al.limit+1, float64(al.cfg.MaxLimit))
+			al.fast = 0
+		}
+	}
+
+	al.inFlight--
+
+	for al.inFlight < al.capacity() && len(al.queue) > 0 {
+		next := al.queue[0]
+		al.queue = al.queue[1:]
+		al.inFlight++
+		next.result <- true
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimitAIMD(t *testing.T) {
+	const target = 100 * time.Millisecond
+	fast, slow := target/2, 3*target
+
+	testCases := []struct {
+		name      string
+		cfg       LoadShedConfig
+		inFlight  int             // held when the latencies come in
+		latencies []time.Duration // simulated, one release and acquire each
+		want      int
+	}{
+		{
+			name:      "a round of fast requests at the limit",
+			cfg:       LoadShedConfig{InitialLimit: 10},
+			inFlight:  10,
+			latencies: repeat(fast, 10),
+			want:      11,
+		},
+		{
+			name:      "five rounds",
+			cfg:       LoadShedConfig{InitialLimit: 10},
+			inFlight:  10,
+			latencies: repeat(fast, 10+11+12+13+14),
+			want:      15,
+		},
+		{
+			name:      "fast requests far below the limit",
+			cfg:       LoadShedConfig{InitialLimit: 10},
+			inFlight:  2,
+			latencies: repeat(fast, 100),
+			want:      10,
+		},
+		{
+			name:      "one slow request",
+			cfg:       LoadShedConfig{InitialLimit: 20},
+			inFlight:  20,
+			latencies: []time.Duration{slow},
+			want:      18,
+		},
+		{
+			name:      "a backoff of its own",
+			cfg:       LoadShedConfig{InitialLimit: 20, Backoff: 0.5},
+			inFlight:  20,
+			latencies: []time.Duration{slow},
+			want:      10,
+		},
+		{
+			name:      "a whole round of slow requests",
+			cfg:       LoadShedConfig{InitialLimit: 20},
+			inFlight:  20,
+			latencies: repeat(slow, 20),
+			want:      18,
+		},
+		{
+			name:      "slow rounds",
+			cfg:       LoadShedConfig{InitialLimit: 20},
+			inFlight:  20,
+			latencies: repeat(slow, 20+18+1),
+			want:      14, // 20 * 0.9 * 0.9 * 0.9, once per round
+		},
+		{
+			name:      "down to the minimum",
+			cfg:       LoadShedConfig{InitialLimit: 20, MinLimit: 4},
+			inFlight:  4,
+			latencies: repeat(slow, 500),
+			want:      4,
+		},
+		{
+			name:      "up to the maximum",
+			cfg:       LoadShedConfig{InitialLimit: 4, MaxLimit: 6},
+			inFlight:  4,
+			latencies: repeat(fast, 100),
+			want:      6,
+		},
+		{
+			name:      "slow ones among fast ones",
+			cfg:       LoadShedConfig{InitialLimit: 10},
+			inFlight:  10,
+			latencies: append(repeat(fast, 10), slow, slow),
+			want:      9, // 11 * 0.9, once for the round
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			tc.cfg.TargetLatency = target
+			al := newAdaptiveLimiter(tc.cfg)
+
+			for range tc.inFlight {
+				al.acquire(context.Background(), PriorityNormal)
+			}
+
+			// a new request only takes a free slot, it does not wait
+			noWait, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			// act: every finished request is followed by a new one
+			for _, latency := range tc.latencies {
+				al.release(latency)
+				al.acquire(noWait, PriorityNormal)
+			}
+
+			// assert
+			if got := al.capacity(); got != tc.want {
+				t.Errorf("limit: got=%v (%.3f), want=%v", got, al.limit, tc.want)
+			}
+		})
+	}
+}
+
+func repeat(d time.Duration, n int) []time.Duration {
+	ds := make([]time.Duration, n)
+
+	for i := range ds {
+		ds[i] = d
+	}
+
+	return ds
+}
+
+// blockingServer serves the requests of a load shedding middleware on
+// handlers that block until released.
+type blockingServer struct {
+	handler http.Handler
+	started chan string
+	release chan struct{}
+}
+
+func newBlockingServer(al *adaptiveLimiter) *blockingServer {
+	bs := &blockingServer{started: make(chan string, 10), release: make(chan struct{})}
+	bs.handler = loadShedding(al)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs.started <- r.URL.Path
+
+		if r.URL.Path != "/healthz" {
+			<-bs.release
+		}
+	}))
+
+	return bs
+}
+
+// get serves a request in the background and returns its status.
+func (bs *blockingServer) get(path string, priority Priority) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	request.Header.Set("X-Priority", string(rune('0'+priority)))
+
+	go func() {
+		rw := httptest.NewRecorder()
+		bs.handler.ServeHTTP(rw, request)
+		done <- rw
+	}()
+
+	return done
+}
+
+func headerPriority(r *http.Request) Priority {
+	if p := DefaultPriority(r); p == PriorityCritical {
+		return p
+	}
+
+	return Priority(r.Header.Get("X-Priority")[0] - '0')
+}
+
+// waitQueued waits until n requests wait in the queue of al.
+func waitQueued(t *testing.T, al *adaptiveLimiter, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		al.mu.Lock()
+		queued := len(al.queue)
+		al.mu.Unlock()
+
+		if queued == n {
+			return
+		}
+	}
+
+	t.Fatalf("%v requests never queued", n)
+}
+
+func TestLoadShedQueueAndShed(t *testing.T) {
+	// asset: one request at a time, one waiting
+	al := newAdaptiveLimiter(LoadShedConfig{InitialLimit: 1, MaxLimit: 1, QueueSize: 1, MaxWait: time.Minute, RetryAfter: 2 * time.Second, Priority: headerPriority})
+	bs := newBlockingServer(al)
+
+	first := bs.get("/chow", PriorityNormal)
+	<-bs.started
+	queued := bs.get("/alan", PriorityNormal)
+	waitQueued(t, al, 1)
+
+	// act
+	shed := <-bs.get("/stu", PriorityNormal)
+	health := <-bs.get("/healthz", PriorityNormal)
+	close(bs.release)
+
+	// assert
+	if shed.Code != http.StatusServiceUnavailable || shed.Header().Get("Retry-After") != "2" {
+		t.Errorf("over the queue: got=(%v, Retry-After %q), want=(%v, %q)", shed.Code, shed.Header().Get("Retry-After"), http.StatusServiceUnavailable, "2")
+	}
+
+	if health.Code != http.StatusOK {
+		t.Errorf("health check: got=%v, want=%v", health.Code, http.StatusOK)
+	}
+
+	for _, done := range []<-chan *httptest.ResponseRecorder{first, queued} {
+		if rw := <-done; rw.Code != http.StatusOK {
+			t.Errorf("got=%v, want=%v", rw.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestLoadShedPriorities(t *testing.T) {
+	// asset: one request at a time, two waiting
+	al := newAdaptiveLimiter(LoadShedConfig{InitialLimit: 1, MaxLimit: 1, QueueSize: 2, MaxWait: time.Minute, Priority: headerPriority})
+	bs := newBlockingServer(al)
+
+	running := bs.get("/running", PriorityNormal)
+	<-bs.started
+	low := bs.get("/low", PriorityLow)
+	waitQueued(t, al, 1)
+	normal := bs.get("/normal", PriorityNormal)
+	waitQueued(t, al, 2)
+
+	// act: the high one pushes the low one out and goes first
+	high := bs.get("/high", PriorityHigh)
+
+	// assert
+	if rw := <-low; rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("low: got=%v, want=%v", rw.Code, http.StatusServiceUnavailable)
+	}
+
+	if rw := <-bs.get("/another-low", PriorityLow); rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("low on a full queue: got=%v, want=%v", rw.Code, http.StatusServiceUnavailable)
+	}
+
+	var order []string
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for range 2 {
+			order = append(order, <-bs.started)
+		}
+	}()
+
+	close(bs.release)
+	wg.Wait()
+
+	if len(order) != 2 || order[0] != "/high" || order[1] != "/normal" {
+		t.Errorf("order: got=%v, want=[/high /normal]", order)
+	}
+
+	for _, done := range []<-chan *httptest.ResponseRecorder{running, normal, high} {
+		if rw := <-done; rw.Code != http.StatusOK {
+			t.Errorf("got=%v, want=%v", rw.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestLoadShedMaxWait(t *testing.T) {
+	// asset
+	al := newAdaptiveLimiter(LoadShedConfig{InitialLimit: 1, MaxLimit: 1, MaxWait: 10 * time.Millisecond, Priority: headerPriority})
+	bs := newBlockingServer(al)
+	defer close(bs.release)
+
+	bs.get("/chow", PriorityNormal)
+	<-bs.started
+
+	// act
+	rw := <-bs.get("/alan", PriorityNormal)
+
+	// assert
+	if rw.Code != http.StatusServiceUnavailable || rw.Header().Get("Retry-After") != "1" {
+		t.Errorf("got=(%v, Retry-After %q), want=(%v, %q)", rw.Code, rw.Header().Get("Retry-After"), http.StatusServiceUnavailable, "1")
+	}
+
+	if len(al.queue) != 0 {
+		t.Errorf("queue: got=%v waiting, want none", len(al.queue))
+	}
+}
+
+func TestLoadShedMiddlewareMeasuresLatency(t *testing.T) {
+	// asset: the handlers take simulated time
+	clock := &manualClock{t: time.Unix(1754537406, 0)}
+	latency := 300 * time.Millisecond
+	cfg := LoadShedConfig{InitialLimit: 10, TargetLatency: 100 * time.Millisecond, now: clock.now}
+	al := newAdaptiveLimiter(cfg)
+	handler := loadShedding(al)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clock.advance(latency)
+	}))
+
+	// act: slow requests bring the limit down once for the round, fast ones
+	// at the limit do not bring it back up while there is no load
+	for range 3 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/chow", nil))
+	}
+
+	latency = 10 * time.Millisecond
+
+	for range 3 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/chow", nil))
+	}
+
+	// assert: 10 * 0.9
+	if got := al.capacity(); got != 9 || al.inFlight != 0 {
+		t.Errorf("got=(limit %v, %v in flight), want=(9, 0)", got, al.inFlight)
+	}
+}
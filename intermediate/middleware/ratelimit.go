@@ -0,0 +1,212 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type RateLimitConfig struct {
+	// Rate is how many requests per second a client may make in the long
+	// run. It must be positive.
+	Rate float64
+
+	// Burst is how many requests a client may make at once, after being
+	// idle; less than 1 means Rate rounded up.
+	Burst int
+
+	// Key tells the clients apart; nil means KeyByIP. Requests with the
+	// same key share one bucket.
+	Key func(*http.Request) string
+
+	// IdleTimeout is how long the bucket of a client that makes no requests
+	// is kept; zero means the time a bucket takes to fill up, after which it
+	// is no different from a new one.
+	IdleTimeout time.Duration
+
+	// MaxClients caps the buckets kept at once, so that clients coming up
+	// with new keys, e.g. from a range of IPv6 addresses, cannot use up the
+	// memory; zero means 10000. While the cap is reached, new clients share
+	// a single bucket until idle ones are evicted.
+	MaxClients int
+
+	now func() time.Time // replaced in tests
+}
+
+// KeyByIP keys clients by the IP address of the connection. Behind a proxy
+// that is the address of the proxy; use a Key that reads what the proxy
+// forwards instead.
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// KeyByPrincipal keys clients by the principal AuthMiddleware verified, e.g.
+// the owner of an API key, and the others by their IP address. Run it behind
+// AuthMiddleware: keying by credentials nobody checked would give a client a
+// new bucket for every key it makes up.
+func KeyByPrincipal(r *http.Request) string {
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		return "principal:" + principal.Method + ":" + principal.Subject
+	}
+
+	return "ip:" + KeyByIP(r)
+}
+
+// RateLimitMiddleware gives every client a token bucket of cfg.Burst tokens
+// that refills at cfg.Rate tokens per second. A request takes a token; a
+// client with an empty bucket gets 429 Too Many Requests and a Retry-After
+// header with the seconds until the next token.
+//
+// Every response has the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers of the IETF draft "RateLimit header fields for
+// HTTP", so well-behaved clients can slow down before they are throttled.
+func RateLimitMiddleware(cfg RateLimitConfig) func(http.Handler) http.Handler {
+	if cfg.Rate <= 0 || math.IsInf(cfg.Rate, 0) || math.IsNaN(cfg.Rate) {
+		panic("RateLimitMiddleware: Rate must be positive")
+	}
+
+	if cfg.Burst < 1 {
+		cfg.Burst = int(math.Ceil(cfg.Rate))
+	}
+
+	if cfg.Key == nil {
+		cfg.Key = KeyByIP
+	}
+
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = seconds(float64(cfg.Burst) / cfg.Rate)
+	}
+
+	if cfg.MaxClients <= 0 {
+		cfg.MaxClients = 10000
+	}
+
+	if cfg.now == nil {
+		cfg.now = time.Now
+	}
+
+	limiter := &rateLimiter{cfg: cfg, buckets: map[string]*tokenBucket{}, swept: cfg.now()}
+
+	return func(handler http.Handler) http.Handler {
+		middleware := func(w http.ResponseWriter, r *http.Request) {
+			decision := limiter.take(cfg.Key(r))
+
+			header := w.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(cfg.Burst))
+			header.Set("RateLimit-Remaining", strconv.Itoa(decision.remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.reset)))
+
+			if !decision.allowed {
+				header.Set("Retry-After", strconv.Itoa(ceilSeconds(decision.retryAfter)))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+
+				return
+			}
+
+			handler.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(middleware)
+	}
+}
+
+type rateLimiter struct {
+	cfg RateLimitConfig
+
+	mu       sync.Mutex
+	buckets  map[string]*tokenBucket
+	overflow *tokenBucket // shared by new clients while MaxClients are kept
+	swept    time.Time    // when idle buckets were last evicted
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // when tokens was last brought up to date
+}
+
+type rateDecision struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration // until the bucket is full again
+	retryAfter time.Duration // until the next token, if not allowed
+}
+
+func (rl *rateLimiter) take(key string) rateDecision {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.cfg.now()
+	rl.evictIdle(now)
+
+	burst := float64(rl.cfg.Burst)
+	bucket, ok := rl.buckets[key]
+
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+
+		if len(rl.buckets) < rl.cfg.MaxClients {
+			rl.buckets[key] = bucket
+		} else {
+			if rl.overflow == nil {
+				rl.overflow = bucket
+			}
+
+			bucket = rl.overflow
+		}
+	}
+
+	elapsed := max(now.Sub(bucket.last), 0)
+	bucket.tokens = min(burst, bucket.tokens+elapsed.Seconds()*rl.cfg.Rate)
+	bucket.last = now
+
+	decision := rateDecision{allowed: bucket.tokens >= 1}
+
+	if decision.allowed {
+		bucket.tokens--
+	} else {
+		decision.retryAfter = seconds((1 - bucket.tokens) / rl.cfg.Rate)
+	}
+
+	decision.remaining = int(bucket.tokens)
+	decision.reset = seconds((burst - bucket.tokens) / rl.cfg.Rate)
+
+	return decision
+}
+
+// evictIdle forgets the buckets nobody used for IdleTimeout. It runs at most
+// once per IdleTimeout, so that there is no scan on every request; the
+// memory is bounded by MaxClients meanwhile.
+func (rl *rateLimiter) evictIdle(now time.Time) {
+	if now.Sub(rl.swept) < rl.cfg.IdleTimeout {
+		return
+	}
+
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.last) >= rl.cfg.IdleTimeout {
+			delete(rl.buckets, key)
+		}
+	}
+
+	if rl.overflow != nil && now.Sub(rl.overflow.last) >= rl.cfg.IdleTimeout {
+		rl.overflow = nil
+	}
+
+	rl.swept = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// manualClock only moves when the test advances it.
+type manualClock struct {
+	t time.Time
+}
+
+func (c *manualClock) now() time.Time {
+	return c.t
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+type rateLimitResult struct {
+	code       int
+	remaining  string
+	reset      string
+	retryAfter string
+}
+
+func limitedGet(handler http.Handler, remoteAddr string, header http.Header) rateLimitResult {
+	request := httptest.NewRequest(http.MethodGet, "/chow", nil)
+	request.RemoteAddr = remoteAddr
+
+	for key, values := range header {
+		request.Header[key] = values
+	}
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, request)
+
+	return rateLimitResult{
+		code:       rw.Code,
+		remaining:  rw.Header().Get("RateLimit-Remaining"),
+		reset:      rw.Header().Get("RateLimit-Reset"),
+		retryAfter: rw.Header().Get("Retry-After"),
+	}
+}
+
+func TestRateLimitBucket(t *testing.T) {
+	// asset
+	clock := &manualClock{t: time.Unix(1754537406, 0)}
+	handler := RateLimitMiddleware(RateLimitConfig{Rate: 0.5, Burst: 3, now: clock.now})(http.NotFoundHandler())
+
+	steps := []struct {
+		wait time.Duration
+		want rateLimitResult
+	}{
+		// the burst, then the bucket is empty
+		{want: rateLimitResult{code: http.StatusNotFound, remaining: "2", reset: "2"}},
+		{want: rateLimitResult{code: http.StatusNotFound, remaining: "1", reset: "4"}},
+		{want: rateLimitResult{code: http.StatusNotFound, remaining: "0", reset: "6"}},
+		{want: rateLimitResult{code: http.StatusTooManyRequests, remaining: "0", reset: "6", retryAfter: "2"}},
+
+		// half a token
+		{wait: time.Second, want: rateLimitResult{code: http.StatusTooManyRequests, remaining: "0", reset: "5", retryAfter: "1"}},
+
+		// one token, taken at once
+		{wait: time.Second, want: rateLimitResult{code: http.StatusNotFound, remaining: "0", reset: "6"}},
+		{want: rateLimitResult{code: http.StatusTooManyRequests, remaining: "0", reset: "6", retryAfter: "2"}},
+
+		// the bucket does not fill up beyond the burst
+		{wait: time.Hour, want: rateLimitResult{code: http.StatusNotFound, remaining: "2", reset: "2"}},
+	}
+
+	for i, step := range steps {
+		clock.advance(step.wait)
+
+		// act
+		got := limitedGet(handler, "192.0.2.1:1234", nil)
+
+		// assert
+		if got != step.want {
+			t.Errorf("request %v: got=%+v, want=%+v", i, got, step.want)
+		}
+	}
+}
+
+// fakeAuth stands in for AuthMiddleware, taking the subject of the principal
+// from the X-Principal header.
+func fakeAuth(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subject := r.Header.Get("X-Principal"); subject != "" {
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, &Principal{Subject: subject, Method: "api_key"}))
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	testCases := []struct {
+		name       string
+		key        func(*http.Request) string
+		remoteAddr string
+		header     http.Header
+		throttled  bool // by the request before, from 192.0.2.1 as principal "chow"
+	}{
+		{name: "same IP", remoteAddr: "192.0.2.1:4321", throttled: true},
+		{name: "other IP", remoteAddr: "192.0.2.2:1234", throttled: false},
+		{name: "IPv6", remoteAddr: "[2001:db8::1]:1234", throttled: false},
+		{
+			name:       "same principal from another IP",
+			key:        KeyByPrincipal,
+			remoteAddr: "192.0.2.2:1234",
+			header:     http.Header{"X-Principal": {"chow"}},
+			throttled:  true,
+		},
+		{
+			name:       "other principal from the same IP",
+			key:        KeyByPrincipal,
+			remoteAddr: "192.0.2.1:1234",
+			header:     http.Header{"X-Principal": {"alan"}},
+			throttled:  false,
+		},
+		{
+			name:       "no principal from the same IP",
+			key:        KeyByPrincipal,
+			remoteAddr: "192.0.2.1:1234",
+			throttled:  false,
+		},
+		{
+			name:       "custom key",
+			key:        func(r *http.Request) string { return "everyone" },
+			remoteAddr: "192.0.2.2:1234",
+			throttled:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			clock := &manualClock{t: time.Unix(1754537406, 0)}
+			handler := fakeAuth(RateLimitMiddleware(RateLimitConfig{Rate: 1, Burst: 1, Key: tc.key, now: clock.now})(http.NotFoundHandler()))
+			limitedGet(handler, "192.0.2.1:1234", http.Header{"X-Principal": {"chow"}})
+
+			// act
+			got := limitedGet(handler, tc.remoteAddr, tc.header)
+
+			// assert
+			if throttled := got.code == http.StatusTooManyRequests; throttled != tc.throttled {
+				t.Errorf("throttled: got=%v, want=%v", throttled, tc.throttled)
+			}
+		})
+	}
+}
+
+func TestRateLimitIgnoresUnverifiedKeys(t *testing.T) {
+	// asset: the rate limit runs before authentication
+	clock := &manualClock{t: time.Unix(1754537406, 0)}
+	limited := RateLimitMiddleware(RateLimitConfig{Rate: 1, Burst: 1, Key: KeyByPrincipal, now: clock.now})
+	apiKeys := NewAPIKeyVerifier("X-API-Key", map[string]string{"kaman-kachick": "chow"})
+	handler := limited(AuthMiddleware(AuthConfig{Verifiers: []Verifier{apiKeys}})(http.NotFoundHandler()))
+
+	// act: a new made-up key with every request
+	throttled := 0
+
+	for i := range 100 {
+		got := limitedGet(handler, "192.0.2.1:1234", http.Header{"X-Api-Key": {fmt.Sprintf("made-up-%v", i)}})
+
+		if got.code == http.StatusTooManyRequests {
+			throttled++
+		}
+	}
+
+	// assert
+	if throttled != 99 {
+		t.Errorf("throttled: got=%v, want=%v", throttled, 99)
+	}
+}
+
+func TestRateLimitMaxClients(t *testing.T) {
+	// asset
+	clock := &manualClock{t: time.Unix(1754537406, 0)}
+	limiter := &rateLimiter{
+		cfg:     RateLimitConfig{Rate: 1, Burst: 1, IdleTimeout: time.Minute, MaxClients: 2, now: clock.now},
+		buckets: map[string]*tokenBucket{},
+		swept:   clock.now(),
+	}
+
+	// act
+	var allowed []bool
+
+	for _, key := range []string{"chow", "alan", "stu", "doug"} {
+		allowed = append(allowed, limiter.take(key).allowed)
+	}
+
+	clock.advance(time.Minute)
+	afterIdle := limiter.take("doug").allowed
+
+	// assert: stu and doug share the bucket over the cap until it is evicted
+	if want := []bool{true, true, true, false}; !slices.Equal(allowed, want) || !afterIdle {
+		t.Errorf("allowed: got=(%v, %v), want=(%v, true)", allowed, afterIdle, want)
+	}
+
+	if len(limiter.buckets) > 2 {
+		t.Errorf("buckets: got=%v, want at most 2", len(limiter.buckets))
+	}
+}
+
+func TestRateLimitEvictsIdleBuckets(t *testing.T) {
+	// asset
+	clock := &manualClock{t: time.Unix(1754537406, 0)}
+	limiter := &rateLimiter{
+		cfg:     RateLimitConfig{Rate: 1, Burst: 2, IdleTimeout: time.Minute, MaxClients: 10, now: clock.now},
+		buckets: map[string]*tokenBucket{},
+		swept:   clock.now(),
+	}
+
+	for _, key := range []string{"chow", "alan", "stu"} {
+		limiter.take(key)
+	}
+
+	clock.advance(50 * time.Second)
+	limiter.take("alan")
+
+	// act
+	clock.advance(20 * time.Second)
+	limiter.take("doug")
+
+	// assert
+	if _, ok := limiter.buckets["chow"]; ok || len(limiter.buckets) != 2 {
+		t.Errorf("buckets: got=%v, want alan and doug", limiter.buckets)
+	}
+}
+
+func TestRateLimitDefaultIdleTimeoutKeepsNoInformation(t *testing.T) {
+	// asset
+	clock := &manualClock{t: time.Unix(1754537406, 0)}
+	handler := RateLimitMiddleware(RateLimitConfig{Rate: 2, Burst: 4, now: clock.now})(http.NotFoundHandler())
+
+	for range 4 {
+		limitedGet(handler, "192.0.2.1:1234", nil)
+	}
+
+	// act: the bucket is full again once it could be evicted
+	clock.advance(2 * time.Second)
+	got := limitedGet(handler, "192.0.2.1:1234", nil)
+
+	// assert
+	if want := (rateLimitResult{code: http.StatusNotFound, remaining: "3", reset: "1"}); got != want {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+}
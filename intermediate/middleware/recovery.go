@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+type RecoveryConfig struct {
+	// Logger receives a record with the stack trace for every panic; nil
+	// means slog.Default().
+	Logger *slog.Logger
+}
+
+// RecoveryMiddleware turns a panicking handler into a logged error and a 500
+// Internal Server Error, instead of a connection closed with nothing but the
+// default net/http log. The response tells the client nothing about the
+// panic, only the request ID if RequestIDMiddleware runs before us.
+//
+// Once the handler has sent the header, the status can no longer change: the
+// response is aborted like net/http does, so the client sees it break off
+// instead of taking half a body for a whole one. http.ErrAbortHandler is a
+// deliberate abort and goes on as it is, without a log.
+func RecoveryMiddleware(cfg RecoveryConfig) func(http.Handler) http.Handler {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	return func(handler http.Handler) http.Handler {
+		middleware := func(w http.ResponseWriter, r *http.Request) {
+			rec := &responseRecorder{ResponseWriter: w}
+
+			defer func() {
+				v := recover()
+
+				if v == nil {
+					return
+				}
+
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+
+				cfg.Logger.LogAttrs(r.Context(), slog.LevelError, "panic",
+					slog.String("panic", fmt.Sprint(v)),
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.String("remote_addr", r.RemoteAddr),
+					slog.String("request_id", RequestID(r)),
+					slog.Bool("header_written", rec.wroteHeader),
+					slog.String("stack", string(debug.Stack())),
+				)
+
+				if rec.wroteHeader || rec.hijacked {
+					panic(http.ErrAbortHandler)
+				}
+
+				internalServerError(w, r)
+			}()
+
+			handler.ServeHTTP(rec, r)
+		}
+
+		return http.HandlerFunc(middleware)
+	}
+}
+
+// internalServerError replies with a 500 as JSON or as plain text, whichever
+// the client prefers.
+func internalServerError(w http.ResponseWriter, r *http.Request) {
+	msg := http.StatusText(http.StatusInternalServerError)
+	id := RequestID(r)
+
+	// the handler may have described a body that never comes; the headers
+	// of the middleware around it stay
+	header := w.Header()
+
+	for _, key := range []string{"Content-Length", "Content-Encoding", "Content-Disposition", "ETag", "Last-Modified"} {
+		header.Del(key)
+	}
+
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	if prefersJSON(r.Header.Values("Accept")) {
+		body := map[string]string{"error": msg}
+
+		if id != "" {
+			body["request_id"] = id
+		}
+
+		header.Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(body)
+
+		return
+	}
+
+	if id != "" {
+		msg += " (request ID " + id + ")"
+	}
+
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintln(w, msg)
+}
+
+// prefersJSON reports whether the Accept headers rank application/json above
+// text/plain. Ties and missing headers go to plain text.
+func prefersJSON(accept []string) bool {
+	jsonQ := acceptQuality(accept, "application", "json")
+
+	return jsonQ > 0 && jsonQ > acceptQuality(accept, "text", "plain")
+}
+
+// acceptQuality is the q-value the Accept headers give typ/subtype, taken from
+// the most specific media range that matches it, or -1 if none does.
+func acceptQuality(accept []string, typ, subtype string) float64 {
+	quality, specificity := -1.0, -1
+
+	for _, value := range accept {
+		for _, item := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(item)
+
+			if err != nil {
+				continue
+			}
+
+			rangeType, rangeSubtype, _ := strings.Cut(mediaType, "/")
+			s := 0
+
+			switch {
+			case rangeType == typ && rangeSubtype == subtype:
+				s = 2
+			case rangeType == typ && rangeSubtype == "*":
+				s = 1
+			case mediaType == "*/*":
+				s = 0
+			default:
+				continue
+			}
+
+			q := 1.0
+
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+
+			if s > specificity {
+				quality, specificity = q, s
+			}
+		}
+	}
+
+	return quality
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryBeforeWriteHeader(t *testing.T) {
+	testCases := []struct {
+		name        string
+		accept      string
+		requestID   string
+		handler     http.HandlerFunc
+		contentType string
+		body        string
+	}{
+		{
+			name: "plain text",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic("Mr. Chow")
+			},
+			contentType: "text/plain; charset=utf-8",
+			body:        "Internal Server Error\n",
+		},
+		{
+			name:      "JSON with request ID",
+			accept:    "text/html, application/json;q=0.9, */*;q=0.1",
+			requestID: "wolfpack",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic("Mr. Chow")
+			},
+			contentType: "application/json",
+			body:        `{"error":"Internal Server Error","request_id":"wolfpack"}` + "\n",
+		},
+		{
+			name:   "with the headers of a body",
+			accept: "application/json;q=0.5, text/*",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				w.Header().Set("Content-Length", "1024")
+				panic("Mr. Chow")
+			},
+			contentType: "text/plain; charset=utf-8",
+			body:        "Internal Server Error\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			var logs bytes.Buffer
+			handler := RecoveryMiddleware(RecoveryConfig{Logger: slog.New(slog.NewJSONHandler(&logs, nil))})(tc.handler)
+
+			if tc.requestID != "" {
+				handler = RequestIDMiddleware(RequestIDConfig{Generate: func() string { return tc.requestID }})(handler)
+			}
+
+			request := httptest.NewRequest(http.MethodGet, "/chow", nil)
+			request.Header.Set("Accept", tc.accept)
+			rw := httptest.NewRecorder()
+
+			// act
+			handler.ServeHTTP(rw, request)
+
+			// assert
+			if rw.Code != http.StatusInternalServerError || rw.Body.String() != tc.body {
+				t.Errorf("got=(%v, %q), want=(%v, %q)", rw.Code, rw.Body.String(), http.StatusInternalServerError, tc.body)
+			}
+
+			if got := rw.Header().Get("Content-Type"); got != tc.contentType || rw.Header().Get("Content-Length") != "" {
+				t.Errorf("Content-Type: got=%v, want=%v without a Content-Length", got, tc.contentType)
+			}
+
+			records := slogRecords(t, &logs)
+
+			if len(records) != 1 {
+				t.Fatalf("records: got=%v, want 1", len(records))
+			}
+
+			record := records[0]
+
+			if record["panic"] != "Mr. Chow" || record["path"] != "/chow" || record["request_id"] != tc.requestID {
+				t.Errorf("record: got=%v", record)
+			}
+
+			if stack, _ := record["stack"].(string); !strings.Contains(stack, "recovery_test.go") {
+				t.Errorf("stack does not lead to the panic: got=%q", stack)
+			}
+		})
+	}
+}
+
+func TestRecoveryAfterWriteHeader(t *testing.T) {
+	// asset
+	var logs bytes.Buffer
+	handler := RecoveryMiddleware(RecoveryConfig{Logger: slog.New(slog.NewJSONHandler(&logs, nil))})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "Kaman! ")
+		panic("Mr. Chow")
+	}))
+
+	rw := httptest.NewRecorder()
+
+	// act
+	var repanicked any
+
+	func() {
+		defer func() {
+			repanicked = recover()
+		}()
+
+		handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/chow", nil))
+	}()
+
+	// assert
+	if repanicked != http.ErrAbortHandler {
+		t.Errorf("panic: got=%v, want=%v", repanicked, http.ErrAbortHandler)
+	}
+
+	if rw.Code != http.StatusOK || rw.Body.String() != "Kaman! " {
+		t.Errorf("the response changed after the header: got=(%v, %q)", rw.Code, rw.Body.String())
+	}
+
+	if records := slogRecords(t, &logs); len(records) != 1 || records[0]["header_written"] != true {
+		t.Errorf("records: got=%v, want one with header_written", records)
+	}
+}
+
+func TestRecoveryAbortsStartedResponse(t *testing.T) {
+	// asset
+	testServer := httptest.NewServer(RecoveryMiddleware(RecoveryConfig{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "Kaman! ")
+		http.NewResponseController(w).Flush()
+		panic("Mr. Chow")
+	})))
+	defer testServer.Close()
+
+	// act
+	response, err := http.Get(testServer.URL)
+
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+
+	defer response.Body.Close()
+	_, err = io.ReadAll(response.Body)
+
+	// assert
+	if response.StatusCode != http.StatusOK || err == nil {
+		t.Errorf("got=(%v, %v), want=(%v, a broken body)", response.StatusCode, err, http.StatusOK)
+	}
+}
+
+func TestRecoveryPassesErrAbortHandlerOn(t *testing.T) {
+	// asset
+	var logs bytes.Buffer
+	handler := RecoveryMiddleware(RecoveryConfig{Logger: slog.New(slog.NewJSONHandler(&logs, nil))})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	rw := httptest.NewRecorder()
+
+	// act
+	var repanicked any
+
+	func() {
+		defer func() {
+			repanicked = recover()
+		}()
+
+		handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/chow", nil))
+	}()
+
+	// assert
+	if repanicked != http.ErrAbortHandler {
+		t.Errorf("panic: got=%v, want=%v", repanicked, http.ErrAbortHandler)
+	}
+
+	if logs.Len() != 0 || rw.Body.Len() != 0 {
+		t.Errorf("got log %q and body %q, want neither", logs.String(), rw.Body.String())
+	}
+}
+
+func TestPrefersJSON(t *testing.T) {
+	testCases := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: false},
+		{accept: "application/json", want: true},
+		{accept: "application/json, text/plain", want: false},
+		{accept: "text/plain;q=0.1, */*", want: true},
+		{accept: "text/plain;q=0.1, application/*", want: true},
+		{accept: "*/*, text/plain;q=0.2, application/json;q=0.4", want: true},
+		{accept: "application/json;q=0", want: false},
+		{accept: "text/html", want: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.accept, func(t *testing.T) {
+			// act
+			got := prefersJSON([]string{tc.accept})
+
+			// assert
+			if got != tc.want {
+				t.Errorf("got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// RequestIDHeader carries the ID of a request between services.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds the incoming IDs we accept, so that a client cannot
+// blow up every log line of a request.
+const maxRequestIDLen = 128
+
+type RequestIDConfig struct {
+	// Header is read from the request and set on the response; empty means
+	// RequestIDHeader.
+	Header string
+
+	// Generate makes the ID of a request that comes without a valid one;
+	// nil means NewUUIDv7. NewULID is the other choice at hand.
+	Generate func() string
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx that carries id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the ID RequestIDMiddleware stored in ctx.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+
+	return id, ok && id != ""
+}
+
+// RequestID returns the ID of the request r, or "" when it has none.
+func RequestID(r *http.Request) string {
+	id, _ := RequestIDFromContext(r.Context())
+
+	return id
+}
+
+// RequestIDMiddleware tags every request with an ID: the one the client sent
+// if it is valid, a new one otherwise. The ID is echoed in the response
+// header and stored in the request context, see RequestIDFromContext.
+//
+// It has to wrap the middleware that logs, e.g. LoggingMiddleware, for their
+// lines to include the ID.
+func RequestIDMiddleware(cfg RequestIDConfig) func(http.Handler) http.Handler {
+	if cfg.Header == "" {
+		cfg.Header = RequestIDHeader
+	}
+
+	if cfg.Generate == nil {
+		cfg.Generate = NewUUIDv7
+	}
+
+	return func(handler http.Handler) http.Handler {
+		middleware := func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(cfg.Header)
+
+			if !validRequestID(id) {
+				id = cfg.Generate()
+			}
+
+			w.Header().Set(cfg.Header, id)
+			handler.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+		}
+
+		return http.HandlerFunc(middleware)
+	}
+}
+
+// validRequestID accepts IDs of letters, digits and "-_.:", which covers
+// UUIDs, ULIDs and the IDs of the usual proxies and load balancers without
+// letting anything into a log line that would need escaping.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+
+	for _, c := range []byte(id) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewUUIDv7 returns a random UUID version 7 (RFC 9562). Its first 48 bits are
+// the Unix time in milliseconds, so the IDs sort by the time they were made.
+func NewUUIDv7() string {
+	var random [10]byte
+	rand.Read(random[:])
+
+	return uuidv7(time.Now(), random)
+}
+
+func uuidv7(now time.Time, random [10]byte) string {
+	var u [16]byte
+
+	binary.BigEndian.PutUint64(u[:8], uint64(now.UnixMilli())<<16)
+	copy(u[6:], random[:])
+	u[6] = 0x70 | u[6]&0x0f // version 7
+	u[8] = 0x80 | u[8]&0x3f // variant 10
+
+	var buf [36]byte
+
+	hex.Encode(buf[0:8], u[0:4])
+	hex.Encode(buf[9:13], u[4:6])
+	hex.Encode(buf[14:18], u[6:8])
+	hex.Encode(buf[19:23], u[8:10])
+	hex.Encode(buf[24:], u[10:])
+	buf[8], buf[13], buf[18], buf[23] = '-', '-', '-', '-'
+
+	return string(buf[:])
+}
+
+// crockford is the base32 alphabet of ULIDs, without I, L, O and U.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a random ULID: 26 characters that, like NewUUIDv7, start
+// with the time in milliseconds and sort by it.
+func NewULID() string {
+	var random [10]byte
+	rand.Read(random[:])
+
+	return ulid(time.Now(), random)
+}
+
+func ulid(now time.Time, random [10]byte) string {
+	var u [16]byte
+
+	binary.BigEndian.PutUint64(u[:8], uint64(now.UnixMilli())<<16)
+	copy(u[6:], random[:])
+
+	// 128 bits in 26 characters of 5 bits each, with 2 bits of padding at
+	// the front
+	hi, lo := binary.BigEndian.Uint64(u[:8]), binary.BigEndian.Uint64(u[8:])
+	var buf [26]byte
+
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+
+	return string(buf[:])
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cfg      RequestIDConfig
+		header   string
+		incoming string
+		want     string // "" means a generated ID
+	}{
+		{name: "no ID", header: RequestIDHeader},
+		{name: "valid ID", header: RequestIDHeader, incoming: "frontend:4f1c-9a_2.b", want: "frontend:4f1c-9a_2.b"},
+		{name: "ID with a space", header: RequestIDHeader, incoming: "mr chow"},
+		{name: "ID with a newline", header: RequestIDHeader, incoming: "chow\nfake log line"},
+		{name: "too long ID", header: RequestIDHeader, incoming: strings.Repeat("a", maxRequestIDLen+1)},
+		{
+			name:     "custom header and generator",
+			cfg:      RequestIDConfig{Header: "X-Correlation-ID", Generate: func() string { return "wolfpack" }},
+			header:   "X-Correlation-ID",
+			incoming: "!",
+			want:     "wolfpack",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			var fromContext string
+			handler := RequestIDMiddleware(tc.cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fromContext = RequestID(r)
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/chow", nil)
+
+			if tc.incoming != "" {
+				request.Header.Set(tc.header, tc.incoming)
+			}
+
+			rw := httptest.NewRecorder()
+
+			// act
+			handler.ServeHTTP(rw, request)
+
+			// assert
+			echoed := rw.Header().Get(tc.header)
+
+			if echoed != fromContext {
+				t.Errorf("response header and context differ: got=(%q, %q)", echoed, fromContext)
+			}
+
+			if tc.want != "" && echoed != tc.want {
+				t.Errorf("got=%q, want=%q", echoed, tc.want)
+			}
+
+			if tc.want == "" && (echoed == tc.incoming || !validRequestID(echoed)) {
+				t.Errorf("got=%q, want a newly generated ID", echoed)
+			}
+		})
+	}
+}
+
+func TestRequestIDFromContextWithout(t *testing.T) {
+	// act
+	id, ok := RequestIDFromContext(httptest.NewRequest(http.MethodGet, "/", nil).Context())
+
+	// assert
+	if id != "" || ok {
+		t.Errorf("got=(%q, %v), want=(%q, %v)", id, ok, "", false)
+	}
+}
+
+func TestLoggingMiddlewaresIncludeRequestID(t *testing.T) {
+	// asset
+	var logs bytes.Buffer
+	defaultOutput := log.Writer()
+	log.SetOutput(&logs)
+	defer log.SetOutput(defaultOutput)
+
+	logger := log.New(&logs, "", 0)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	handlers := []http.Handler{
+		RequestIDMiddleware(RequestIDConfig{})(AddLoggingMiddleware(ok)),
+		RequestIDMiddleware(RequestIDConfig{})(&LoggingMiddleware{logger: logger, handler: ok}),
+	}
+
+	for _, handler := range handlers {
+		request := httptest.NewRequest(http.MethodGet, "/alan", nil)
+		request.Header.Set(RequestIDHeader, "up-there")
+
+		// act
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	// assert
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+
+	if len(lines) != 2 {
+		t.Fatalf("log lines: got=%q, want 2", lines)
+	}
+
+	for _, line := range lines {
+		if !strings.Contains(line, "GET /alan ") || !strings.HasSuffix(line, " request_id=up-there") {
+			t.Errorf("got=%q, want the request ID at the end", line)
+		}
+	}
+}
+
+func TestUUIDv7(t *testing.T) {
+	// asset: the example of RFC 9562, appendix A.6
+	now := time.UnixMilli(0x017F22E279B0)
+	random := [10]byte{0x0C, 0xC3, 0x18, 0xC4, 0xDC, 0x0C, 0x0C, 0x07, 0x39, 0x8F}
+
+	// act
+	got := uuidv7(now, random)
+
+	// assert
+	if want := "017f22e2-79b0-7cc3-98c4-dc0c0c07398f"; got != want {
+		t.Errorf("got=%v, want=%v", got, want)
+	}
+
+	if id := NewUUIDv7(); !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(id) {
+		t.Errorf("NewUUIDv7: got=%v, want a version 7 UUID", id)
+	}
+}
+
+func TestULID(t *testing.T) {
+	testCases := []struct {
+		name   string
+		now    time.Time
+		random [10]byte
+		want   string
+	}{
+		{name: "zero", now: time.UnixMilli(0), want: "00000000000000000000000000"},
+		{
+			name:   "largest",
+			now:    time.UnixMilli(1<<48 - 1),
+			random: [10]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			want:   "7ZZZZZZZZZZZZZZZZZZZZZZZZZ",
+		},
+		{name: "timestamp", now: time.UnixMilli(1469918176385), want: "01ARYZ6S410000000000000000"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// act
+			got := ulid(tc.now, tc.random)
+
+			// assert
+			if got != tc.want {
+				t.Errorf("got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGeneratedIDsSortByTime(t *testing.T) {
+	for _, generate := range []func(time.Time, [10]byte) string{uuidv7, ulid} {
+		// asset
+		earlier, later := time.UnixMilli(1754537406000), time.UnixMilli(1754537406001)
+
+		// act
+		first := generate(earlier, [10]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+		second := generate(later, [10]byte{})
+
+		// assert
+		if first >= second || !validRequestID(first) {
+			t.Errorf("got=%v before %v, want them sorted", first, second)
+		}
+	}
+}
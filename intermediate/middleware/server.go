@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 	"log"
+	"middleware/chain"
 	"net"
 	"net/http"
+	"os"
 	"time"
 )
 
@@ -15,16 +17,24 @@ type LoggingMiddleware struct {
 
 func (lm *LoggingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// log the method of the request, path(endpoint), and the current time
-	lm.logger.Println(r.Method, r.URL.Path, time.Now())
+	lm.logger.Println(logArgs(r)...)
 
 	// hands the request over to the given handler
 	lm.handler.ServeHTTP(w, r)
 }
 
+// NewLoggingMiddleware makes LoggingMiddleware a link of a chain.Chain, which
+// logs with logger.
+func NewLoggingMiddleware(logger *log.Logger) chain.Middleware {
+	return func(handler http.Handler) http.Handler {
+		return &LoggingMiddleware{logger: logger, handler: handler}
+	}
+}
+
 func AddLoggingMiddleware(handler http.Handler) http.Handler {
 	middlware := func(w http.ResponseWriter, r *http.Request) {
 		// log the method of the request, path(endpoint), and the current time
-		log.Println(r.Method, r.URL.Path, time.Now())
+		log.Println(logArgs(r)...)
 
 		// hands the request over to the given handler
 		handler.ServeHTTP(w, r)
@@ -33,6 +43,18 @@ func AddLoggingMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(middlware)
 }
 
+// logArgs are the values of a log line about r, with the request ID when
+// RequestIDMiddleware runs before us.
+func logArgs(r *http.Request) []any {
+	args := []any{r.Method, r.URL.Path, time.Now()}
+
+	if id, ok := RequestIDFromContext(r.Context()); ok {
+		args = append(args, "request_id="+id)
+	}
+
+	return args
+}
+
 func main() {
 	// mux and handlers
 	mux := http.NewServeMux()
@@ -45,12 +67,35 @@ func main() {
 		fmt.Fprintf(w, "Hey Alan, what are you doing up there!")
 	})
 
+	// a route for the wolfpack only, who know the key in WOLFPACK_API_KEY
+	wolfpack := NewAPIKeyVerifier("X-API-Key", map[string]string{os.Getenv("WOLFPACK_API_KEY"): "wolfpack"})
+	mux.Handle("/wolfpack", AuthMiddleware(AuthConfig{Verifiers: []Verifier{wolfpack}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := PrincipalFromContext(r.Context())
+		fmt.Fprintf(w, "Welcome back, %v!", principal.Subject)
+	})))
+
+	// a route with a timeout of its own
+	mux.Handle("/chow/slow", TimeoutMiddleware(TimeoutConfig{Timeout: 2 * time.Second})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(3 * time.Second):
+			fmt.Fprintf(w, "Kaman! Kachick!")
+		case <-r.Context().Done():
+		}
+	})))
+
 	// add our middleware here
 	// mainHandler := AddLoggingMiddleware(mux)
-	mainHandler := &LoggingMiddleware{
-		logger:  log.Default(),
-		handler: mux,
-	}
+
+	// or a whole chain of them, in the order they see the request:
+	mainHandler := chain.New(
+		RequestIDMiddleware(RequestIDConfig{}),
+		NewLoggingMiddleware(log.Default()), // or AddLoggingMiddleware
+		AccessLogMiddleware(AccessLogConfig{Format: FormatCombined}),
+		RecoveryMiddleware(RecoveryConfig{}),
+		LoadShedMiddleware(LoadShedConfig{}),
+		RateLimitMiddleware(RateLimitConfig{Rate: 5, Burst: 10}),
+		CompressMiddleware(CompressConfig{}),
+	).Then(mux)
 
 	// listener
 	listener, err := net.Listen("tcp", ":8080") // if you need to pass context, use ListeConfig.Listen
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"middleware/chain"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoggingMiddlewareInChain(t *testing.T) {
+	// asset
+	var logs bytes.Buffer
+	defaultOutput := log.Writer()
+	log.SetOutput(&logs)
+	defer log.SetOutput(defaultOutput)
+
+	logger := log.New(&logs, "struct ", 0)
+	handler := chain.New(RequestIDMiddleware(RequestIDConfig{}), NewLoggingMiddleware(logger)).
+		Append(AddLoggingMiddleware).
+		ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+			log.Println("handler")
+		})
+
+	request := httptest.NewRequest(http.MethodGet, "/chow", nil)
+	request.Header.Set(RequestIDHeader, "wolfpack")
+
+	// act
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	// assert
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "struct GET /chow ") || !strings.Contains(lines[1], " GET /chow ") || !strings.HasSuffix(lines[2], "handler") {
+		t.Fatalf("got=%q, want the struct, the closure and then the handler", lines)
+	}
+
+	for _, line := range lines[:2] {
+		if !strings.HasSuffix(line, " request_id=wolfpack") {
+			t.Errorf("got=%q, want the request ID", line)
+		}
+	}
+}
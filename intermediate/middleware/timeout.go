@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers in which clients and upstream services send how long they are
+// willing to wait, and in which SetTimeoutHeaders passes the rest on.
+const (
+	RequestTimeoutHeader = "Request-Timeout" // seconds, e.g. "2.5"
+	GRPCTimeoutHeader    = "grpc-timeout"    // digits and a unit, e.g. "2500m"
+)
+
+type TimeoutConfig struct {
+	// Timeout bounds how long the handler may run. Zero means no bound of
+	// our own, only the budget the request brings in its headers.
+	Timeout time.Duration
+
+	// Status answers requests whose deadline passed before the handler
+	// wrote anything; zero means 503 Service Unavailable. 504 Gateway
+	// Timeout suits a handler that mostly waits for other services.
+	Status int
+}
+
+// TimeoutMiddleware puts a deadline on the context of the request: cfg.Timeout
+// from now, or sooner if the request carries a smaller budget in a
+// Request-Timeout or grpc-timeout header. A budget can only shorten the
+// deadline, never extend it. Wrap single handlers to give routes different
+// timeouts.
+//
+// If the deadline passes before the handler wrote anything, the client gets
+// cfg.Status at once and whatever the handler writes later fails with
+// http.ErrHandlerTimeout. If it passes after, the response is the handler's:
+// unlike http.TimeoutHandler nothing is buffered, so streaming responses,
+// Flush and http.ResponseController work as usual, and a handler that streams
+// is expected to stop when its context is done.
+func TimeoutMiddleware(cfg TimeoutConfig) func(http.Handler) http.Handler {
+	if cfg.Status == 0 {
+		cfg.Status = http.StatusServiceUnavailable
+	}
+
+	return func(handler http.Handler) http.Handler {
+		middleware := func(w http.ResponseWriter, r *http.Request) {
+			timeout, ok := requestBudget(r.Header)
+
+			if !ok || (cfg.Timeout > 0 && cfg.Timeout < timeout) {
+				timeout = cfg.Timeout
+			}
+
+			if timeout <= 0 {
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			tw := &timeoutWriter{w: w, header: http.Header{}}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+
+			go func() {
+				defer func() {
+					if v := recover(); v != nil {
+						if v != http.ErrAbortHandler {
+							v = fmt.Sprintf("%v\n\n%s", v, debug.Stack())
+						}
+
+						panicked <- v
+						return
+					}
+
+					close(done)
+				}()
+
+				handler.ServeHTTP(tw, r.WithContext(ctx))
+			}()
+
+			select {
+			case <-done:
+				return
+			case v := <-panicked:
+				panic(v)
+			case <-ctx.Done():
+			}
+
+			tw.mu.Lock()
+
+			if tw.wroteHeader {
+				// the response is the handler's, let it finish
+				tw.mu.Unlock()
+
+				select {
+				case <-done:
+				case v := <-panicked:
+					panic(v)
+				}
+
+				return
+			}
+
+			tw.timedOut = true
+			tw.mu.Unlock()
+
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(cfg.Status)
+			fmt.Fprintln(w, http.StatusText(cfg.Status))
+		}
+
+		return http.HandlerFunc(middleware)
+	}
+}
+
+// timeoutWriter stands between the handler and the response, which the
+// middleware may take over at the deadline. Until the handler writes, its
+// header lives in a map of its own, so a late handler cannot race with the
+// timeout response.
+type timeoutWriter struct {
+	w http.ResponseWriter
+
+	mu          sync.Mutex
+	header      http.Header
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.wroteHeader {
+		return tw.w.Header() // for trailers
+	}
+
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return
+	}
+
+	tw.writeHeaderLocked(code)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	if tw.wroteHeader {
+		tw.w.WriteHeader(code) // let net/http complain
+		return
+	}
+
+	header := tw.w.Header()
+
+	for key, values := range tw.header {
+		header[key] = values
+	}
+
+	// informational responses such as 103 Early Hints come before the
+	// real one, which the middleware may still replace
+	if code >= 200 || code == http.StatusSwitchingProtocols {
+		tw.wroteHeader = true
+	}
+
+	tw.w.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+
+	return tw.w.Write(p)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.FlushError()
+}
+
+// FlushError is Flush for http.ResponseController, which reports its error.
+func (tw *timeoutWriter) FlushError() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return http.ErrHandlerTimeout
+	}
+
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+
+	return http.NewResponseController(tw.w).Flush()
+}
+
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+
+	conn, rw, err := http.NewResponseController(tw.w).Hijack()
+
+	if err == nil {
+		tw.wroteHeader = true // the connection is the handler's now
+	}
+
+	return conn, rw, err
+}
+
+func (tw *timeoutWriter) SetReadDeadline(deadline time.Time) error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return http.ErrHandlerTimeout
+	}
+
+	return http.NewResponseController(tw.w).SetReadDeadline(deadline)
+}
+
+func (tw *timeoutWriter) SetWriteDeadline(deadline time.Time) error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return http.ErrHandlerTimeout
+	}
+
+	return http.NewResponseController(tw.w).SetWriteDeadline(deadline)
+}
+
+func (tw *timeoutWriter) EnableFullDuplex() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return http.ErrHandlerTimeout
+	}
+
+	return http.NewResponseController(tw.w).EnableFullDuplex()
+}
+
+// Unwrap gives http.ResponseController whatever else of the response there
+// is no method for here, until the deadline took the response over: the
+// connection may serve the next request by the time a late handler gets to
+// it.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return nil
+	}
+
+	return tw.w
+}
+
+// requestBudget returns the smaller of the budgets in the Request-Timeout and
+// grpc-timeout headers. Values it cannot parse are ignored.
+func requestBudget(header http.Header) (time.Duration, bool) {
+	budget, ok := time.Duration(0), false
+
+	consider := func(d time.Duration) {
+		if d > 0 && (!ok || d < budget) {
+			budget, ok = d, true
+		}
+	}
+
+	if v := header.Get(RequestTimeoutHeader); v != "" {
+		if s, err := strconv.ParseFloat(v, 64); err == nil && s > 0 && s < math.MaxInt64/float64(time.Second) {
+			consider(seconds(s))
+		}
+	}
+
+	if v := header.Get(GRPCTimeoutHeader); v != "" {
+		if d, ok := parseGRPCTimeout(v); ok {
+			consider(d)
+		}
+	}
+
+	return budget, ok
+}
+
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// parseGRPCTimeout parses the grpc-timeout format: at most 8 digits followed
+// by a unit, H, M, S, m, u or n.
+func parseGRPCTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+
+	unit, ok := grpcTimeoutUnits[v[len(v)-1]]
+	n, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+
+	if !ok || err != nil {
+		return 0, false
+	}
+
+	// 99999999 hours do not fit in a time.Duration
+	if n > uint64(math.MaxInt64/unit) {
+		return math.MaxInt64, true
+	}
+
+	return time.Duration(n) * unit, true
+}
+
+// SetTimeoutHeaders passes the time left until the deadline of ctx on to a
+// request to another service, in both the Request-Timeout and the
+// grpc-timeout header. It does nothing for a context without a deadline.
+func SetTimeoutHeaders(ctx context.Context, header http.Header) {
+	deadline, ok := ctx.Deadline()
+
+	if !ok {
+		return
+	}
+
+	left := max(time.Until(deadline), time.Millisecond)
+
+	header.Set(RequestTimeoutHeader, strconv.FormatFloat(left.Seconds(), 'f', 3, 64))
+	header.Set(GRPCTimeoutHeader, formatGRPCTimeout(left))
+}
+
+// formatGRPCTimeout rounds d up to the finest unit that keeps it within the
+// 8 digits grpc-timeout allows.
+func formatGRPCTimeout(d time.Duration) string {
+	for _, unit := range []byte{'n', 'u', 'm', 'S', 'M', 'H'} {
+		size := grpcTimeoutUnits[unit]
+		n := (d + size - 1) / size
+
+		if n <= 99999999 {
+			return strconv.FormatInt(int64(n), 10) + string(unit)
+		}
+	}
+
+	return "99999999H"
+}
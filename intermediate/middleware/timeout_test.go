@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutBeforeWrite(t *testing.T) {
+	testCases := []struct {
+		name       string
+		cfg        TimeoutConfig
+		header     http.Header
+		wantStatus int
+	}{
+		{name: "own timeout", cfg: TimeoutConfig{Timeout: 20 * time.Millisecond}, wantStatus: http.StatusServiceUnavailable},
+		{
+			name:       "gateway timeout",
+			cfg:        TimeoutConfig{Timeout: 20 * time.Millisecond, Status: http.StatusGatewayTimeout},
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:       "budget of the request",
+			header:     http.Header{"Grpc-Timeout": {"20m"}},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "budget shorter than the own timeout",
+			cfg:        TimeoutConfig{Timeout: time.Hour},
+			header:     http.Header{"Request-Timeout": {"0.02"}},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// asset
+			answered := make(chan struct{})
+			lateWrite := make(chan error, 1)
+			handler := TimeoutMiddleware(tc.cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+				<-answered
+
+				w.Header().Set("X-Late", "yes")
+				_, err := io.WriteString(w, "too late")
+				lateWrite <- err
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/chow", nil)
+			request.Header = tc.header
+			rw := httptest.NewRecorder()
+
+			// act
+			handler.ServeHTTP(rw, request)
+			close(answered)
+
+			// assert
+			if rw.Code != tc.wantStatus || rw.Body.String() != http.StatusText(tc.wantStatus)+"\n" {
+				t.Errorf("got=(%v, %q), want=(%v, %q)", rw.Code, rw.Body.String(), tc.wantStatus, http.StatusText(tc.wantStatus)+"\n")
+			}
+
+			if err := <-lateWrite; !errors.Is(err, http.ErrHandlerTimeout) {
+				t.Errorf("late write: got=%v, want=%v", err, http.ErrHandlerTimeout)
+			}
+
+			if rw.Header().Get("X-Late") != "" {
+				t.Errorf("the header of the late handler reached the response")
+			}
+		})
+	}
+}
+
+func TestTimeoutFastHandler(t *testing.T) {
+	// asset
+	var deadline time.Time
+	var hasDeadline bool
+	handler := TimeoutMiddleware(TimeoutConfig{Timeout: time.Hour})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, hasDeadline = r.Context().Deadline()
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "Kaman! Kachick!")
+	}))
+
+	request := httptest.NewRequest(http.MethodGet, "/chow", nil)
+	request.Header.Set(RequestTimeoutHeader, "not a number")
+	rw := httptest.NewRecorder()
+
+	// act
+	handler.ServeHTTP(rw, request)
+
+	// assert
+	if rw.Code != http.StatusCreated || rw.Body.String() != "Kaman! Kachick!" || rw.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("got=(%v, %q, %v)", rw.Code, rw.Body.String(), rw.Header())
+	}
+
+	if left := time.Until(deadline); !hasDeadline || left < 59*time.Minute || left > time.Hour {
+		t.Errorf("deadline: got=(%v, %v left), want an hour from now", hasDeadline, left)
+	}
+}
+
+func TestTimeoutPanicReachesTheServer(t *testing.T) {
+	// asset
+	handler := TimeoutMiddleware(TimeoutConfig{Timeout: time.Hour})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	// act
+	var repanicked any
+
+	func() {
+		defer func() {
+			repanicked = recover()
+		}()
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/chow", nil))
+	}()
+
+	// assert
+	if repanicked != http.ErrAbortHandler {
+		t.Errorf("got=%v, want=%v", repanicked, http.ErrAbortHandler)
+	}
+}
+
+func TestTimeoutKeepsStreaming(t *testing.T) {
+	// asset
+	handlerErr := make(chan error, 1)
+	testServer := httptest.NewServer(TimeoutMiddleware(TimeoutConfig{Timeout: 50 * time.Millisecond})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+
+		if err := rc.SetWriteDeadline(time.Now().Add(time.Minute)); err != nil {
+			handlerErr <- err
+			return
+		}
+
+		io.WriteString(w, "first\n")
+
+		if err := rc.Flush(); err != nil {
+			handlerErr <- err
+			return
+		}
+
+		<-r.Context().Done()
+		io.WriteString(w, "last\n")
+		handlerErr <- r.Context().Err()
+	})))
+	defer testServer.Close()
+
+	// act
+	response, err := http.Get(testServer.URL)
+
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+
+	defer response.Body.Close()
+	reader := bufio.NewReader(response.Body)
+	first, _ := reader.ReadString('\n')
+	rest, _ := io.ReadAll(reader)
+
+	// assert
+	if response.StatusCode != http.StatusOK || first != "first\n" || string(rest) != "last\n" {
+		t.Errorf("got=(%v, %q, %q), want=(%v, %q, %q)", response.StatusCode, first, rest, http.StatusOK, "first\n", "last\n")
+	}
+
+	if err := <-handlerErr; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("handler: got=%v, want=%v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestTimeoutLateResponseController(t *testing.T) {
+	// asset
+	answered := make(chan struct{})
+	before := make(chan error, 1)
+	late := make(chan []error, 1)
+	testServer := httptest.NewServer(TimeoutMiddleware(TimeoutConfig{Timeout: 50 * time.Millisecond})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		before <- rc.SetWriteDeadline(time.Now().Add(time.Minute))
+
+		<-r.Context().Done()
+		<-answered
+
+		late <- []error{
+			rc.SetReadDeadline(time.Now()),
+			rc.SetWriteDeadline(time.Now()),
+			rc.EnableFullDuplex(),
+			rc.Flush(),
+		}
+	})))
+	defer testServer.Close()
+
+	// act
+	response, err := http.Get(testServer.URL)
+
+	if err != nil {
+		t.Fatalf("unexpected client error: %v", err)
+	}
+
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	close(answered)
+
+	// assert
+	if err := <-before; err != nil {
+		t.Errorf("before the deadline: got=%v, want=nil", err)
+	}
+
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status: got=%v, want=%v", response.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	for i, err := range <-late {
+		if !errors.Is(err, http.ErrHandlerTimeout) {
+			t.Errorf("late call %v: got=%v, want=%v", i, err, http.ErrHandlerTimeout)
+		}
+	}
+}
+
+func TestRequestBudget(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "none", header: http.Header{}},
+		{name: "seconds", header: http.Header{"Request-Timeout": {"2.5"}}, want: 2500 * time.Millisecond, wantOK: true},
+		{name: "grpc milliseconds", header: http.Header{"Grpc-Timeout": {"250m"}}, want: 250 * time.Millisecond, wantOK: true},
+		{name: "grpc hours", header: http.Header{"Grpc-Timeout": {"2H"}}, want: 2 * time.Hour, wantOK: true},
+		{
+			name:   "the smaller one",
+			header: http.Header{"Request-Timeout": {"1"}, "Grpc-Timeout": {"3000000u"}},
+			want:   time.Second,
+			wantOK: true,
+		},
+		{name: "too many digits", header: http.Header{"Grpc-Timeout": {"123456789m"}}},
+		{name: "unknown unit", header: http.Header{"Grpc-Timeout": {"10s"}}},
+		{name: "negative", header: http.Header{"Request-Timeout": {"-1"}}},
+		{name: "infinite", header: http.Header{"Request-Timeout": {"Inf"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// act
+			got, ok := requestBudget(tc.header)
+
+			// assert
+			if got != tc.want || ok != tc.wantOK {
+				t.Errorf("got=(%v, %v), want=(%v, %v)", got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestSetTimeoutHeaders(t *testing.T) {
+	// asset
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	header := http.Header{}
+	noDeadline := http.Header{}
+
+	// act
+	SetTimeoutHeaders(ctx, header)
+	SetTimeoutHeaders(context.Background(), noDeadline)
+
+	// assert
+	budget, ok := requestBudget(header)
+
+	if !ok || budget > 2*time.Second || budget < time.Second {
+		t.Errorf("passed on budget: got=(%v, %v) from %v, want about 2s", budget, ok, header)
+	}
+
+	if len(noDeadline) != 0 {
+		t.Errorf("without a deadline: got=%v, want no headers", noDeadline)
+	}
+}
+
+func TestFormatGRPCTimeout(t *testing.T) {
+	testCases := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 5 * time.Millisecond, want: "5000000n"},
+		{d: 2 * time.Second, want: "2000000u"},
+		{d: 250 * time.Second, want: "250000m"},
+		{d: 100*time.Hour + time.Nanosecond, want: "360001S"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.want, func(t *testing.T) {
+			// act
+			got := formatGRPCTimeout(tc.d)
+
+			// assert
+			if got != tc.want {
+				t.Errorf("got=%v, want=%v", got, tc.want)
+			}
+
+			if d, _ := parseGRPCTimeout(got); d < tc.d {
+				t.Errorf("round trip: got=%v, want at least %v", d, tc.d)
+			}
+		})
+	}
+}